@@ -15,9 +15,14 @@ func main() {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	slog.SetDefault(logger)
 
-	// 2. Initialize Redis Queue (Producer Mode)
-	// We point to localhost:6379 since we are running outside the container network
-	redisQ := queue.NewRedisQueue("localhost:6379", "goxec:jobs", "goxec:workers")
+	// 2. Initialize the Job Queue (Producer Mode). GOXEC_QUEUE_DRIVER selects
+	// redis (default), memory, nats, or kafka; addr defaults to localhost:6379
+	// since we're running outside the container network.
+	jobQueue, err := queue.NewFromEnv("localhost:6379", "{goxec:jobs}:stream", "goxec:workers")
+	if err != nil {
+		slog.Error("Failed to initialize job queue", "error", err)
+		os.Exit(1)
+	}
 
 	// 3. Publish Jobs
 	for i := 1; i <= 5; i++ {
@@ -28,7 +33,7 @@ func main() {
 		}
 
 		slog.Info("Publishing job", "jobID", job.ID)
-		if err := redisQ.Publish(context.Background(), job); err != nil {
+		if _, err := jobQueue.Publish(context.Background(), job); err != nil {
 			slog.Error("Failed to publish job", "error", err)
 			os.Exit(1)
 		}
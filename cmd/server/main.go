@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log/slog"
 	"net/http"
 	"os"
 
     "github.com/dontdude/goxec/internal/domain"
+	"github.com/dontdude/goxec/internal/metrics"
 	"github.com/dontdude/goxec/internal/platform/queue"
+	"github.com/dontdude/goxec/internal/platform/web"
+	"github.com/dontdude/goxec/internal/runtime"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
@@ -17,21 +21,73 @@ func main() {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	slog.SetDefault(logger)
 
-	// 2. Initialize Redis Queue (as a dependency)
-	redisQ := queue.NewRedisQueue("localhost:6379", "goxec:jobs", "goxec:workers")
+	// 2. Initialize the Job Queue (dependency). GOXEC_QUEUE_DRIVER selects
+	// redis (default), memory, nats, or kafka.
+	jobQueue, err := queue.NewFromEnv("localhost:6379", "{goxec:jobs}:stream", "goxec:workers")
+	if err != nil {
+		slog.Error("Failed to initialize job queue", "error", err)
+		os.Exit(1)
+	}
+
+	// 2b. Language Registry, so clients can discover supported runtimes.
+	languages := runtime.NewLanguageRegistry()
+	if path := os.Getenv("GOXEC_LANGUAGES_CONFIG"); path != "" {
+		loaded, err := runtime.LoadLanguageRegistry(path)
+		if err != nil {
+			slog.Error("Failed to load language config, falling back to defaults", "path", path, "error", err)
+		} else {
+			languages = loaded
+		}
+	}
+
+	// 2c. Rate limiter guarding /submit. GOXEC_RATELIMIT_DRIVER selects memory
+	// (the default, per-replica) or redis, so a fleet behind a load balancer
+	// can share capacity instead of each replica granting its own.
+	limiter := web.NewLimiterFromEnv(5, 10, "localhost:6379")
 
 	// 3. Setup Router (Standard Lib)
 	mux := http.NewServeMux()
 
 	// 4. Register Handlers
 	// Post /submit -> Enqueues Job
-	mux.HandleFunc("POST /submit", handleSubmit(redisQ))
-	// Get /ws -> WebSocket Updgrade
-	mux.HandleFunc("GET /ws", handleWS())
+	mux.HandleFunc("POST /submit", limiter.RateLimitMiddleware(handleSubmit(jobQueue)))
+	// Get /dlq -> Inspect dead-lettered jobs
+	mux.HandleFunc("GET /dlq", handleListDead(jobQueue))
+	// Post /dlq/{id}/requeue -> Replay a dead-lettered job
+	mux.HandleFunc("POST /dlq/{id}/requeue", handleRequeueDead(jobQueue))
+	// Get /languages -> Advertise supported runtimes to the frontend
+	mux.HandleFunc("GET /languages", handleListLanguages(languages))
+	// Get /result/{id} -> Fetch a cached job result (post-crash reconnect, polling)
+	mux.HandleFunc("GET /result/{id}", handleResult(jobQueue))
+
+	// Get /ws -> WebSocket Updgrade; clients send {"subscribe":"<job_id>"} to
+	// receive that job's live output. Live streaming rides on Redis pub/sub,
+	// so it's only wired up when that's the configured driver.
+	if redisQ, ok := jobQueue.(*queue.RedisQueue); ok {
+		streamHub := newHub(redisQ)
+		mux.HandleFunc("GET /ws", handleWS(streamHub))
+	} else {
+		slog.Warn("Live-output streaming (/ws) is unavailable: GOXEC_QUEUE_DRIVER is not \"redis\"")
+	}
 
 	// 4. Middleware (CORS)
 	handler := enableCORS(mux)
 
+	// 5. Admin listener (metrics only), separate from the user-facing API so
+	// scraping it isn't subject to the rate limiter guarding /submit.
+	adminAddr := os.Getenv("GOXEC_ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = ":9090"
+	}
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("GET /metrics", metrics.Default.Handler())
+	go func() {
+		slog.Info("Admin listener starting", "addr", adminAddr)
+		if err := http.ListenAndServe(adminAddr, adminMux); err != nil {
+			slog.Error("Admin listener failed", "error", err)
+		}
+	}()
+
 	slog.Info("API Server starting on :8080")
 	if err := http.ListenAndServe(":8080", handler); err != nil {
 		slog.Error("Server failed", "error", err)
@@ -39,13 +95,27 @@ func main() {
 	}
 }
 
+// publish enqueues job on q, routing it to a specific worker by consistent
+// hash when q supports it (currently only *queue.RedisQueue) so per-worker
+// warmed language runtime/image caches stay effective; other drivers fall
+// back to the ordinary Publish.
+func publish(ctx context.Context, q domain.JobQueue, job domain.Job) (string, error) {
+	if router, ok := q.(interface {
+		PublishRouted(ctx context.Context, job domain.Job) (string, error)
+	}); ok {
+		return router.PublishRouted(ctx, job)
+	}
+	return q.Publish(ctx, job)
+}
+
 // handleSubmit creates a closure to inject the Queue dependency.
 func handleSubmit(q domain.JobQueue) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Define Request Payload
 		var req struct {
-			Code	 string `json:"code"`
-			Language string `json:"language"`
+			Code           string `json:"code"`
+			Language       string `json:"language"`
+			IdempotencyKey string `json:"idempotency_key"`
 		}
 
 		// Decode JSON
@@ -60,27 +130,116 @@ func handleSubmit(q domain.JobQueue) http.HandlerFunc {
 			return
 		}
 
+		// The header takes precedence over the body field so HTTP clients can
+		// set it without touching their request payload.
+		idempotencyKey := r.Header.Get("Idempotency-Key")
+		if idempotencyKey == "" {
+			idempotencyKey = req.IdempotencyKey
+		}
+
 		// Create Job with UUID
 		jobID := uuid.New().String()
 		job := domain.Job{
-			ID: 	  jobID,
-			Code: 	  req.Code,
-			Language: req.Language,
+			ID:             jobID,
+			Code:           req.Code,
+			Language:       req.Language,
+			IdempotencyKey: idempotencyKey,
 		}
 
-		// Enqueue to Redis
+		// Enqueue on the configured queue driver. If idempotencyKey de-duplicates against an earlier
+		// submission, publishedID is that earlier job's ID instead of jobID.
 		slog.Info("Received submission", "jobID", jobID)
-		if err := q.Publish(r.Context(), job); err != nil {
+		publishedID, err := publish(r.Context(), q, job)
+		if err != nil {
 			slog.Error("Failed to publish job", "error", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
+		status := "queued"
+		if publishedID != jobID {
+			status = "duplicate"
+		}
+
 		// Return JSON Response
 		w.Header().Set("Content-type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{
-			"job_id": jobID,
-			"status": "queued",
+			"job_id": publishedID,
+			"status": status,
+		})
+	}
+}
+
+// handleListDead returns the dead-lettered jobs so operators can inspect
+// why they were dropped before deciding whether to requeue them.
+func handleListDead(q domain.JobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dead, err := q.ListDead(r.Context(), 100)
+		if err != nil {
+			slog.Error("Failed to list dead-lettered jobs", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-type", "application/json")
+		json.NewEncoder(w).Encode(dead)
+	}
+}
+
+// handleRequeueDead replays a single dead-lettered job back onto the live queue.
+func handleRequeueDead(q domain.JobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if id == "" {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+
+		slog.Info("Requeueing dead-lettered job", "id", id)
+		if err := q.RequeueDead(r.Context(), id); err != nil {
+			slog.Error("Failed to requeue dead-lettered job", "id", id, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "requeued"})
+	}
+}
+
+// handleResult fetches a job's cached JobResult, so clients that retried a
+// submission (or reconnected after a crash) can recover its outcome instead
+// of resubmitting. It responds 404 until the job finishes.
+func handleResult(q domain.JobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobID := r.PathValue("id")
+		if jobID == "" {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+
+		result, found, err := q.Result(r.Context(), jobID)
+		if err != nil {
+			slog.Error("Failed to fetch job result", "jobID", jobID, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Result not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// handleListLanguages advertises the languages the worker fleet can execute.
+func handleListLanguages(languages *runtime.LanguageRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{
+			"languages": languages.Languages(),
 		})
 	}
 }
@@ -90,25 +249,44 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {return true}, // Allow all origins for dev
 }
 
-// handleWS upgrades the connection to WebSocket.
-func handleWS() http.HandlerFunc {
+// handleWS upgrades the connection to WebSocket and relays live job output.
+// Clients subscribe to a job's output by sending {"subscribe":"<job_id>"};
+// they may send multiple subscribe frames to watch several jobs at once.
+func handleWS(h *hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			slog.Error("WebSocket upgrade failed", "error", err)
 			return
 		}
-		defer conn.Close()
+
+		client := newWSClient(conn)
+		go client.writeLoop()
 
 		slog.Info("Client connected via WebSocket", "remoteAddr", conn.RemoteAddr())
 
-		// Stub Loop: Keep connection alive until client disconnects
+		defer func() {
+			h.unsubscribeAll(client)
+			close(client.send)
+		}()
+
 		for {
-			// Read message (ignore content for now)
-			_, _, err := conn.ReadMessage()
+			_, msg, err := conn.ReadMessage()
 			if err != nil {
 				slog.Info("Client Disconnected", "error", err)
-				break
+				return
+			}
+
+			var frame struct {
+				Subscribe string `json:"subscribe"`
+			}
+			if err := json.Unmarshal(msg, &frame); err != nil {
+				slog.Warn("Ignoring malformed WebSocket frame", "error", err)
+				continue
+			}
+			if frame.Subscribe != "" {
+				slog.Info("Client subscribed to job", "jobID", frame.Subscribe)
+				h.subscribe(frame.Subscribe, client)
 			}
 		}
 	}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dontdude/goxec/internal/domain"
+	"github.com/dontdude/goxec/internal/platform/queue"
+	"github.com/gorilla/websocket"
+)
+
+// clientBufferSize bounds how many unsent stream frames a client may queue
+// before individual frames start being dropped.
+const clientBufferSize = 32
+
+// maxConsecutiveDrops disconnects a client that falls far enough behind that
+// it is dropping frames back-to-back, rather than letting it hold a stream
+// subscription open forever while contributing nothing but backpressure.
+const maxConsecutiveDrops = 20
+
+// wsClient owns one WebSocket connection's outbound side: a single writer
+// goroutine drains send so concurrent broadcasts never race on conn.WriteMessage.
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+
+	consecutiveDrops int32
+}
+
+func newWSClient(conn *websocket.Conn) *wsClient {
+	return &wsClient{conn: conn, send: make(chan []byte, clientBufferSize)}
+}
+
+// writeLoop is the only goroutine allowed to write to conn. It exits (and
+// closes the connection) once send is closed or a write fails.
+func (c *wsClient) writeLoop() {
+	defer c.conn.Close()
+	for msg := range c.send {
+		if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return
+		}
+	}
+}
+
+// hub fans out live job output to subscribed WebSocket clients. Each job ID
+// gets at most one Redis subscription, shared by every client watching it.
+type hub struct {
+	mu sync.Mutex
+	// subs maps a job ID to the clients currently subscribed to it.
+	subs map[string]map[*wsClient]struct{}
+	// cancel stops the Redis subscription for a job once its last subscriber leaves.
+	cancel map[string]context.CancelFunc
+
+	queue *queue.RedisQueue
+}
+
+func newHub(q *queue.RedisQueue) *hub {
+	return &hub{
+		subs:   make(map[string]map[*wsClient]struct{}),
+		cancel: make(map[string]context.CancelFunc),
+		queue:  q,
+	}
+}
+
+// subscribe adds client to jobID's subscriber set, starting a Redis
+// subscription for that job if it doesn't already have one.
+func (h *hub) subscribe(jobID string, client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs[jobID] == nil {
+		h.subs[jobID] = make(map[*wsClient]struct{})
+	}
+	h.subs[jobID][client] = struct{}{}
+
+	if _, running := h.cancel[jobID]; !running {
+		ctx, cancel := context.WithCancel(context.Background())
+		h.cancel[jobID] = cancel
+		go h.pump(ctx, jobID)
+	}
+}
+
+// unsubscribeAll removes client from every job it was watching, tearing down
+// a job's Redis subscription once its last subscriber is gone.
+func (h *hub) unsubscribeAll(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for jobID, clients := range h.subs {
+		if _, ok := clients[client]; !ok {
+			continue
+		}
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(h.subs, jobID)
+			if cancel, ok := h.cancel[jobID]; ok {
+				cancel()
+				delete(h.cancel, jobID)
+			}
+		}
+	}
+}
+
+// pump relays one job's Redis stream to every subscribed client until ctx is
+// cancelled (last subscriber left) or the job's "exit" event is observed.
+func (h *hub) pump(ctx context.Context, jobID string) {
+	events, err := h.queue.SubscribeStream(ctx, jobID)
+	if err != nil {
+		slog.Error("Failed to subscribe to job stream", "jobID", jobID, "error", err)
+		return
+	}
+
+	for event := range events {
+		h.broadcast(jobID, event)
+	}
+
+	h.mu.Lock()
+	delete(h.cancel, jobID)
+	h.mu.Unlock()
+}
+
+// broadcast fans event out to every client subscribed to jobID, dropping the
+// frame for any client whose send buffer is full, and disconnecting clients
+// that fall too far behind to keep up.
+func (h *hub) broadcast(jobID string, event domain.StreamEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to marshal stream event", "jobID", jobID, "error", err)
+		return
+	}
+
+	h.mu.Lock()
+	clients := make([]*wsClient, 0, len(h.subs[jobID]))
+	for client := range h.subs[jobID] {
+		clients = append(clients, client)
+	}
+	h.mu.Unlock()
+
+	for _, client := range clients {
+		select {
+		case client.send <- data:
+			atomic.StoreInt32(&client.consecutiveDrops, 0)
+		default:
+			if atomic.AddInt32(&client.consecutiveDrops, 1) >= maxConsecutiveDrops {
+				slog.Warn("Disconnecting slow websocket client", "jobID", jobID)
+				client.conn.Close()
+			}
+		}
+	}
+}
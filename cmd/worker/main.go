@@ -1,80 +1,250 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/dontdude/goxec/internal/domain"
+	"github.com/dontdude/goxec/internal/metrics"
 	"github.com/dontdude/goxec/internal/platform/docker"
+	"github.com/dontdude/goxec/internal/platform/queue"
+	"github.com/dontdude/goxec/internal/runtime"
 	"github.com/dontdude/goxec/internal/worker"
 )
 
+// shutdownGracePeriod is how long Shutdown lets in-flight jobs finish on
+// their own before cancelling their contexts to force a timely exit.
+const shutdownGracePeriod = 30 * time.Second
+
+// recoveryInterval is how often the queue's periodic recovery routine sweeps
+// the PEL for messages claimed by a worker that went on to crash.
+const recoveryInterval = 30 * time.Second
+
+// recoveryMaxAge is how long a pending entry must be idle before the
+// recovery routine reclaims it.
+const recoveryMaxAge = 2 * time.Minute
+
+// heartbeatInterval is how often this worker refreshes its consistent-hash
+// routing heartbeat, comfortably inside queue.defaultWorkerTTL so a brief
+// hiccup doesn't make PublishRouted think the worker is gone.
+const heartbeatInterval = 15 * time.Second
+
 func main() {
 	// 1. Initialize Logger
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	slog.SetDefault(logger)
 	slog.Info("Starting Goxec Worker Node...")
 
-	// 2. Initialize Docker Client
-	dockerClient := docker.NewClient()
+	// 2. Initialize Language Registry
+	// GOXEC_LANGUAGES_CONFIG optionally points at a JSON file overriding the
+	// built-in runtime profiles (image, resource limits, sandbox options).
+	languages := runtime.NewLanguageRegistry()
+	if path := os.Getenv("GOXEC_LANGUAGES_CONFIG"); path != "" {
+		loaded, err := runtime.LoadLanguageRegistry(path)
+		if err != nil {
+			slog.Error("Failed to load language config, falling back to defaults", "path", path, "error", err)
+		} else {
+			languages = loaded
+		}
+	}
+
+	// 3. Initialize Docker Client
+	dockerClient := docker.NewClient(languages)
+
+	// 4. Initialize the Job Queue (Consumer Mode). GOXEC_QUEUE_DRIVER selects
+	// redis (default), memory, nats, or kafka; GOXEC_QUEUE_ADDR overrides the
+	// default address redis:6379 (the "redis" host on the container network).
+	jobQueue, err := queue.NewFromEnv("redis:6379", "{goxec:jobs}:stream", "goxec:workers")
+	if err != nil {
+		slog.Error("Failed to initialize job queue", "error", err)
+		os.Exit(1)
+	}
+
+	// 5. Handle shutdown signals ourselves (rather than signal.NotifyContext)
+	// so a second signal during the grace period can be distinguished from
+	// the first: the first asks for a graceful drain, the second demands an
+	// immediate hammer. Buffered to 2 so a signal sent before we're ready to
+	// act on the first isn't dropped.
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	// subscribeCtx governs Subscribe/SubscribeWorker, the heartbeat, and the
+	// recovery routine: all of them should stop the moment the first
+	// shutdown signal arrives.
+	subscribeCtx, cancelSubscribe := context.WithCancel(context.Background())
+	defer cancelSubscribe()
 
-	// 3. Initialize Worker Pool (Concurrency: 3)
+	// 6. Initialize Worker Pool (Concurrency: 3). Jobs run under their own
+	// long-lived context, independent of subscribeCtx: if job contexts were
+	// children of subscribeCtx, cancelling it on the first shutdown signal
+	// would kill every in-flight job immediately instead of giving them
+	// shutdownGracePeriod to finish. Shutdown cuts them off explicitly once
+	// its own deadline elapses.
 	concurrency := 3
 	pool := worker.NewPool(concurrency, dockerClient)
-	pool.Start()
-	defer pool.Stop() // Ensure cleanup on exit
+	pool.SetQueue(jobQueue)
+	if streamer, ok := jobQueue.(worker.StreamPublisher); ok {
+		pool.SetStreamer(streamer)
+	}
+	pool.Start(context.Background())
 
-	// 4. Handle Shutdown Signals
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	// 7. Start the recovery routine so jobs left pending by a crashed worker
+	// get reclaimed even while this worker is healthy and just busy.
+	go jobQueue.StartRecoveryRoutine(subscribeCtx, recoveryInterval, recoveryMaxAge)
 
-	// 5. Submit Test Jobs
-	// We'll create a Result Channel to read back results
-	resultCh := make(chan domain.JobResult)
+	// 8. Subscribe to the queue and feed every job into the pool. Results are
+	// reported back on a shared channel; the worker pool itself acknowledges
+	// or dead-letters each job against jobQueue once it finishes.
+	//
+	// Only RedisQueue supports consistent-hash routing (it needs the
+	// heartbeat sorted set): when that's the backend, this worker also
+	// registers a heartbeat and subscribes to its own dedicated stream
+	// alongside the shared one, so routed jobs and Stateless/fallback jobs
+	// both reach it.
+	var jobsCh <-chan domain.Job
+	if redisQ, ok := jobQueue.(*queue.RedisQueue); ok {
+		workerID := os.Getenv("GOXEC_WORKER_ID")
+		if workerID == "" {
+			if h, herr := os.Hostname(); herr == nil && h != "" {
+				workerID = h
+			} else {
+				workerID = fmt.Sprintf("worker-%d", time.Now().UnixNano())
+			}
+		}
+		slog.Info("Registering for consistent-hash job routing", "workerID", workerID)
+		go redisQ.StartHeartbeat(subscribeCtx, workerID, heartbeatInterval)
+
+		routedCh, err := redisQ.SubscribeWorker(subscribeCtx, workerID)
+		if err != nil {
+			slog.Error("Failed to subscribe to routed job stream", "error", err)
+			os.Exit(1)
+		}
+		sharedCh, err := redisQ.Subscribe(subscribeCtx)
+		if err != nil {
+			slog.Error("Failed to subscribe to shared job stream", "error", err)
+			os.Exit(1)
+		}
+		jobsCh = mergeJobs(routedCh, sharedCh)
+	} else {
+		slog.Warn("Consistent-hash job routing is unavailable: GOXEC_QUEUE_DRIVER is not \"redis\"")
+		ch, err := jobQueue.Subscribe(subscribeCtx)
+		if err != nil {
+			slog.Error("Failed to subscribe to job queue", "error", err)
+			os.Exit(1)
+		}
+		jobsCh = ch
+	}
 
+	resultCh := make(chan domain.JobResult)
 	go func() {
-		// Verify: Submit 5 jobs (more than concurrency) to see buffering/wait
-		for i := 1; i <= 5; i++ {
-			jobID := fmt.Sprintf("job-%d", i)
-			code := fmt.Sprintf("print('Hello from Job %d')", i)
-			
-			slog.Info("Submitting job", "jobID", jobID)
-			pool.Submit(domain.Job{
-				ID:       jobID,
-				Code:     code,
-				Language: "python",
-				ResultCh: resultCh,
-			})
-			// Slight delay to simulate staggered arrival, or just blast them
-			time.Sleep(100 * time.Millisecond)
+		for job := range jobsCh {
+			job.ResultCh = resultCh
+			// A non-nil error here means the pool rejected the job before
+			// it was ever queued: either the runner's circuit breaker
+			// reports the image for job.Language as currently failing, or
+			// Shutdown has already begun. The job is left
+			// un-acked, so the queue's own recovery routine redelivers it
+			// once the breaker cools down or it exhausts its retry budget.
+			if err := pool.Submit(job); err != nil {
+				slog.Warn("Job rejected before queueing", "jobID", job.ID, "error", err)
+				resultCh <- domain.JobResult{JobID: job.ID, Error: err.Error()}
+			}
 		}
 	}()
 
-	// 6. Loop and Wait
-	// In a real app, this would be consuming from Redis.
-	// Here we just wait for 5 results or a signal.
-	completed := 0
+	// 9. Loop and Wait
 	for {
 		select {
 		case res := <-resultCh:
-			completed++
-			if res.Error != nil {
-				slog.Error("Job failed", "error", res.Error)
+			if res.Error != "" {
+				slog.Error("Job failed", "jobID", res.JobID, "error", res.Error)
 			} else {
-				slog.Info("Job completed", "output", res.Output)
-			}
-			if completed == 5 {
-				slog.Info("All verification jobs completed. Exiting.")
-				return
+				slog.Info("Job completed", "jobID", res.JobID, "exitCode", res.ExitCode, "durationMs", res.DurationMs)
 			}
 		case <-sigCh:
-			slog.Info("Shutdown signal received")
-			// defer pool.Stop() will run now
+			slog.Info("Shutdown signal received, starting graceful shutdown", "gracePeriod", shutdownGracePeriod)
+			cancelSubscribe()
+
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownGracePeriod)
+			go func() {
+				select {
+				case <-sigCh:
+					slog.Warn("Second shutdown signal received, forcing immediate termination")
+					cancelShutdown()
+				case <-shutdownCtx.Done():
+				}
+			}()
+
+			// Keep draining resultCh while Shutdown waits: workers deliver each
+			// finished job's result on it and would otherwise block there, so
+			// Shutdown could never return.
+			drainDone := make(chan struct{})
+			go func() {
+				for {
+					select {
+					case res := <-resultCh:
+						slog.Info("Job finished during shutdown", "jobID", res.JobID, "error", res.Error)
+					case <-drainDone:
+						return
+					}
+				}
+			}()
+
+			summary := pool.Shutdown(shutdownCtx)
+			close(drainDone)
+			cancelShutdown()
+			if summary.Killed > 0 {
+				slog.Warn("Worker pool stopped with forcibly killed jobs", "completed", summary.Completed, "killed", summary.Killed)
+			} else {
+				slog.Info("Worker pool stopped", "completed", summary.Completed, "killed", summary.Killed)
+			}
+			logLatencyReport()
 			return
 		}
 	}
 }
+
+// logLatencyReport prints a one-off execution-time summary alongside the
+// "Worker pool stopped" log line, so a local run gives an immediate sense of
+// how fast jobs ran without needing to scrape /metrics.
+func logLatencyReport() {
+	summary := metrics.ExecutionTime.Summary()
+	if summary.Count == 0 {
+		return
+	}
+	slog.Info("Execution latency report",
+		"count", summary.Count,
+		"fastest", summary.Fastest,
+		"slowest", summary.Slowest,
+		"p50", summary.P50,
+		"p95", summary.P95,
+		"p99", summary.P99,
+	)
+}
+
+// mergeJobs fans multiple job channels into one, closing the result once
+// every input channel has closed.
+func mergeJobs(chs ...<-chan domain.Job) <-chan domain.Job {
+	out := make(chan domain.Job)
+	var wg sync.WaitGroup
+	wg.Add(len(chs))
+	for _, ch := range chs {
+		go func(ch <-chan domain.Job) {
+			defer wg.Done()
+			for job := range ch {
+				out <- job
+			}
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
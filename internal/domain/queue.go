@@ -1,18 +1,65 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // JobQueue defines the contract for a distributed job queue.
 // It decouples the application from the underlying message broker (Redis, RabbitMQ, etc.).
 type JobQueue interface {
-	// Publish enqueues a job for processing.
-	Publish(ctx context.Context, job Job) error
+	// Publish enqueues a job for processing. It returns the job ID that was
+	// actually enqueued: normally job.ID, but if job.IdempotencyKey matches a
+	// still-live key from an earlier submission, Publish skips enqueueing
+	// entirely and returns that earlier job's ID instead.
+	Publish(ctx context.Context, job Job) (string, error)
 
 	// Subscribe returns a read-only channel that streams jobs from the queue.
 	// It handles the details of consumer groups and acknowledgments internally.
 	Subscribe(ctx context.Context) (<-chan Job, error)
 
-	// Acknowledge confirms that a job has been successfully processed. 
+	// Acknowledge confirms that a job has been successfully processed.
 	// This removes it from the Pending Entry list (PEL).
 	Acknowledge(ctx context.Context, jobID string) error
+
+	// DeadLetter moves a job that has exhausted its retries out of the
+	// Pending Entry List and into the dead-letter stream, recording why it died.
+	DeadLetter(ctx context.Context, job Job, reason string) error
+
+	// ListDead returns up to limit dead-lettered jobs, most recent first,
+	// so operators can inspect failures without touching Redis directly.
+	ListDead(ctx context.Context, limit int64) ([]DeadJob, error)
+
+	// RequeueDead re-publishes a dead-lettered job (by its dead-stream entry ID)
+	// back onto the live queue and removes it from the dead-letter stream.
+	RequeueDead(ctx context.Context, id string) error
+
+	// StoreResult caches a job's finished JobResult so Result can serve
+	// repeat submissions and post-crash reconnects without re-running the job.
+	StoreResult(ctx context.Context, result JobResult) error
+
+	// Result looks up a previously cached JobResult for jobID. The second
+	// return value is false if no result has been stored yet (or it expired).
+	Result(ctx context.Context, jobID string) (JobResult, bool, error)
+}
+
+// DeadJob is a job that exceeded MaxRetries, annotated with enough context
+// for an operator to decide whether to requeue or discard it.
+type DeadJob struct {
+	// ID is the entry ID within the dead-letter stream (distinct from Job.ID).
+	ID string
+
+	Job Job
+
+	// Reason is the last error that caused the job to be dead-lettered.
+	Reason string
+
+	// OriginalStreamID is the Pending Entry List ID the job held before dead-lettering.
+	OriginalStreamID string
+
+	// Consumer is the worker consumer name that last held the job.
+	Consumer string
+
+	// DeadLetteredAt is when the job was moved to the dead-letter stream.
+	DeadLetteredAt time.Time
 }
\ No newline at end of file
@@ -1,6 +1,9 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // Output represents the result of an isolated code execution.
 // It encapsulates the standard output and potential metadata.
@@ -12,8 +15,33 @@ type Output struct {
 // Implementations of this interface handle the low-level container lifecycle management.
 type ContainerRunner interface {
 	// Run executes the provided code snippet in a container for the specified language.
-	// It returns the execution output or an error if the container fails to start or run.
-	Run(ctx context.Context, code string, language string) (string, error)
+	// It returns the structured execution result (exit code, split stdout/stderr,
+	// timing) on success. The returned error is reserved for infrastructure
+	// failures (image pull, container create/start) that prevented the code
+	// from running at all; a non-zero exit code is reported via JobResult.ExitCode,
+	// not as an error.
+	//
+	// jobID identifies the job so streamed events can be attributed and
+	// correlated with its eventual JobResult.
+	//
+	// events, if non-nil, receives incremental StreamEvents as the container
+	// produces output, in addition to the final JobResult this method returns.
+	// Callers that only care about the final result may pass a nil channel.
+	Run(ctx context.Context, jobID string, code string, language string, events chan<- StreamEvent) (JobResult, error)
+}
+
+// StreamEvent is one incremental chunk of live container output, published
+// while a job is still running so subscribers see output as it happens
+// rather than waiting for the final JobResult.
+type StreamEvent struct {
+	JobID string
+	// Kind is "stdout", "stderr", or "exit".
+	Kind string
+	Data []byte
+	// Seq is a per-job monotonically increasing sequence number, shared by
+	// all kinds of a job's events, letting subscribers detect gaps from
+	// dropped/backpressured delivery.
+	Seq int64
 }
 
 // Job represents a unit of work to be executed.
@@ -24,11 +52,76 @@ type Job struct {
 	Language string
 	// ResultCh is where the worker sends the execution result.
 	// It is a send only channel (chan<-) to ensure the worker cannot read from it.
-	ResultCh chan<- JobResult
+	ResultCh chan<- JobResult `json:"-"`
+
+	// RawID is the underlying queue entry ID (e.g. a Redis Stream ID).
+	// It is populated by JobQueue.Subscribe and is required to Acknowledge
+	// or DeadLetter the job later; producers should leave it empty.
+	RawID string `json:"-"`
+
+	// DeliveryCount tracks how many times this job has been handed to a
+	// consumer without being acknowledged. It is maintained by the queue,
+	// not the producer.
+	DeliveryCount int64 `json:"-"`
+
+	// MaxRetries caps DeliveryCount before the queue dead-letters the job.
+	// Zero means "use the queue's default".
+	MaxRetries int64 `json:"max_retries,omitempty"`
+
+	// LastError records the most recent failure reason, carried into the
+	// dead-letter stream if the job exhausts its retries.
+	LastError string `json:"-"`
+
+	// Consumer is the queue consumer name (worker or recovery agent) that
+	// currently holds this job. Populated by JobQueue.Subscribe.
+	Consumer string `json:"-"`
+
+	// IdempotencyKey, if set, de-duplicates submissions: Publish skips
+	// enqueueing entirely if a still-live job was already published under
+	// the same key, returning that job's ID instead.
+	IdempotencyKey string `json:"-"`
+
+	// TimeoutSeconds caps how long the worker pool lets this job run before
+	// cancelling its context. Zero means "no per-job deadline" (the job still
+	// runs within whatever deadline the pool's root context carries, if any).
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+
+	// Stateless opts a job out of consistent-hash worker affinity routing
+	// (see RedisQueue.PublishRouted): it's published to the shared stream any
+	// worker can pick up, instead of a specific worker's dedicated stream.
+	// Set this for jobs that wouldn't benefit from a warmed per-worker
+	// language runtime/image cache.
+	Stateless bool `json:"stateless,omitempty"`
 }
 
-// JobResult encapsulates the result of a job execution.
+// JobResult encapsulates the structured result of a job execution, with
+// stdout and stderr kept separate so frontends can color-code them.
 type JobResult struct {
-	Output string
-	Error  error
+	JobID string
+
+	Stdout string
+	Stderr string
+
+	ExitCode int
+
+	StartedAt  time.Time
+	FinishedAt time.Time
+	DurationMs int64
+
+	// TruncatedStdout/TruncatedStderr report whether the respective buffer
+	// hit its size limit and was cut short.
+	TruncatedStdout bool
+	TruncatedStderr bool
+
+	// TimedOut is true when the job was killed by its context deadline rather
+	// than exiting on its own.
+	TimedOut bool
+	// OOMKilled is true when the container's cgroup killed it for exceeding
+	// its memory limit (container.InspectResponse.State.OOMKilled).
+	OOMKilled bool
+
+	// Error carries an infrastructure-level failure message (e.g. image pull
+	// or container create failed). It is a string, not an error, so JobResult
+	// marshals cleanly over Redis pub/sub and the WebSocket.
+	Error string
 }
\ No newline at end of file
@@ -0,0 +1,330 @@
+// Package metrics provides lightweight, dependency-free Counter and
+// Histogram primitives exposed in Prometheus text exposition format, plus a
+// Summary of retained samples for one-off slog reports (e.g. on shutdown)
+// where a scrape isn't practical.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// labelSep joins a metric's label values into a single map key. It's a
+// control character, so it can't collide with a real label value.
+const labelSep = "\x1f"
+
+// defaultBuckets are the histogram bucket upper bounds, in seconds, used
+// unless a metric is created with its own.
+var defaultBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// maxSamples bounds how many observations a Histogram retains for Summary,
+// per label combination. Older samples are overwritten round-robin once the
+// bound is hit; this is a crude reservoir, not a statistically unbiased one,
+// but is good enough for a rough p50/p95/p99 shutdown report.
+const maxSamples = 10000
+
+// Counter counts occurrences of an event, optionally broken down by one or
+// more labels (pass none for an unlabeled counter).
+type Counter struct {
+	mu         sync.Mutex
+	name       string
+	help       string
+	labelNames []string
+	counts     map[string]float64
+}
+
+func newCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{name: name, help: help, labelNames: labelNames, counts: make(map[string]float64)}
+}
+
+// Inc increments the counter for the given label values, in the order the
+// counter's labelNames were declared. Call with no arguments for an
+// unlabeled counter.
+func (c *Counter) Inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[strings.Join(labelValues, labelSep)]++
+}
+
+func (c *Counter) write(sb *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(sb, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(sb, "# TYPE %s counter\n", c.name)
+
+	keys := make([]string, 0, len(c.counts))
+	for k := range c.counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(sb, "%s%s %g\n", c.name, c.labelsFor(k), c.counts[k])
+	}
+}
+
+func (c *Counter) labelsFor(key string) string {
+	if len(c.labelNames) == 0 {
+		return ""
+	}
+	values := strings.Split(key, labelSep)
+	pairs := make([]string, len(c.labelNames))
+	for i, name := range c.labelNames {
+		pairs[i] = fmt.Sprintf("%s=%q", name, values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// histogramState is one label combination's accumulated observations.
+type histogramState struct {
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+	samples      []float64
+}
+
+// Histogram tracks observations of a duration-valued metric, maintaining
+// both cumulative Prometheus-style bucket counts and a bounded sample window
+// per label combination for Summary.
+type Histogram struct {
+	mu         sync.Mutex
+	name       string
+	help       string
+	buckets    []float64
+	labelNames []string
+	states     map[string]*histogramState
+}
+
+func newHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if buckets == nil {
+		buckets = defaultBuckets
+	}
+	return &Histogram{name: name, help: help, buckets: buckets, labelNames: labelNames, states: make(map[string]*histogramState)}
+}
+
+// Observe records a single observation (in seconds) for the given label
+// values, in the order the histogram's labelNames were declared.
+func (h *Histogram) Observe(seconds float64, labelValues ...string) {
+	key := strings.Join(labelValues, labelSep)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st, ok := h.states[key]
+	if !ok {
+		st = &histogramState{bucketCounts: make([]uint64, len(h.buckets))}
+		h.states[key] = st
+	}
+
+	st.sum += seconds
+	st.count++
+	for i, b := range h.buckets {
+		if seconds <= b {
+			st.bucketCounts[i]++
+		}
+	}
+
+	if len(st.samples) < maxSamples {
+		st.samples = append(st.samples, seconds)
+	} else {
+		st.samples[int(st.count)%maxSamples] = seconds
+	}
+}
+
+func (h *Histogram) write(sb *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(sb, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(sb, "# TYPE %s histogram\n", h.name)
+
+	keys := make([]string, 0, len(h.states))
+	for k := range h.states {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		st := h.states[k]
+		base := h.labelsFor(k)
+
+		// bucketCounts are already cumulative (see Observe).
+		for i, b := range h.buckets {
+			fmt.Fprintf(sb, "%s_bucket%s %d\n", h.name, h.labelsWithLe(base, fmt.Sprintf("%g", b)), st.bucketCounts[i])
+		}
+		fmt.Fprintf(sb, "%s_bucket%s %d\n", h.name, h.labelsWithLe(base, "+Inf"), st.count)
+		fmt.Fprintf(sb, "%s_sum%s %g\n", h.name, base, st.sum)
+		fmt.Fprintf(sb, "%s_count%s %d\n", h.name, base, st.count)
+	}
+}
+
+func (h *Histogram) labelsFor(key string) string {
+	if len(h.labelNames) == 0 {
+		return ""
+	}
+	values := strings.Split(key, labelSep)
+	pairs := make([]string, len(h.labelNames))
+	for i, name := range h.labelNames {
+		pairs[i] = fmt.Sprintf("%s=%q", name, values[i])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// labelsWithLe appends the Prometheus "le" bucket-boundary label to an
+// already-built label list, handling the no-other-labels case.
+func (h *Histogram) labelsWithLe(labels, le string) string {
+	lePair := fmt.Sprintf(`le=%q`, le)
+	if labels == "" {
+		return "{" + lePair + "}"
+	}
+	return "{" + labels + "," + lePair + "}"
+}
+
+// Summary reports latency percentiles computed from every sample a
+// Histogram has retained, across all label combinations, for a one-off
+// report (e.g. a slog line on shutdown) rather than a Prometheus scrape.
+type Summary struct {
+	Count   int
+	Fastest time.Duration
+	Slowest time.Duration
+	P50     time.Duration
+	P95     time.Duration
+	P99     time.Duration
+}
+
+// Summary computes h's percentile Summary. It returns the zero Summary if
+// no observations have been recorded.
+func (h *Histogram) Summary() Summary {
+	h.mu.Lock()
+	var samples []float64
+	for _, st := range h.states {
+		samples = append(samples, st.samples...)
+	}
+	h.mu.Unlock()
+
+	if len(samples) == 0 {
+		return Summary{}
+	}
+	sort.Float64s(samples)
+
+	toDuration := func(seconds float64) time.Duration {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	percentile := func(p float64) time.Duration {
+		idx := int(math.Ceil(p*float64(len(samples)))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		if idx >= len(samples) {
+			idx = len(samples) - 1
+		}
+		return toDuration(samples[idx])
+	}
+
+	return Summary{
+		Count:   len(samples),
+		Fastest: toDuration(samples[0]),
+		Slowest: toDuration(samples[len(samples)-1]),
+		P50:     percentile(0.50),
+		P95:     percentile(0.95),
+		P99:     percentile(0.99),
+	}
+}
+
+// Registry holds a process's metrics and serves them in Prometheus text
+// exposition format.
+type Registry struct {
+	mu         sync.Mutex
+	counters   []*Counter
+	histograms []*Histogram
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter creates and registers a Counter, optionally labeled by
+// labelNames.
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	c := newCounter(name, help, labelNames...)
+	r.mu.Lock()
+	r.counters = append(r.counters, c)
+	r.mu.Unlock()
+	return c
+}
+
+// NewHistogram creates and registers a Histogram. buckets may be nil to use
+// defaultBuckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := newHistogram(name, help, buckets, labelNames...)
+	r.mu.Lock()
+	r.histograms = append(r.histograms, h)
+	r.mu.Unlock()
+	return h
+}
+
+// Handler returns an http.HandlerFunc serving every metric registered on r
+// in Prometheus text exposition format.
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		counters := append([]*Counter(nil), r.counters...)
+		histograms := append([]*Histogram(nil), r.histograms...)
+		r.mu.Unlock()
+
+		var sb strings.Builder
+		for _, c := range counters {
+			c.write(&sb)
+		}
+		for _, h := range histograms {
+			h.write(&sb)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(sb.String()))
+	}
+}
+
+// Default is the process-wide registry, analogous to slog's default logger:
+// mount Default.Handler() on an admin listener's /metrics route, and use the
+// package-level metrics below from any call site without threading a
+// Registry through every constructor.
+var Default = NewRegistry()
+
+// Failure reasons recorded against JobsFailed.
+const (
+	ReasonTimeout      = "timeout"
+	ReasonOOM          = "oom"
+	ReasonImagePull    = "image_pull"
+	ReasonRuntimeError = "runtime_error"
+)
+
+var (
+	// JobsSubmitted counts jobs accepted by worker.Pool.Submit.
+	JobsSubmitted = Default.NewCounter("goxec_jobs_submitted_total", "Jobs submitted to the worker pool.")
+
+	// JobsCompleted counts jobs a worker finished running, by language,
+	// regardless of whether they succeeded or failed.
+	JobsCompleted = Default.NewCounter("goxec_jobs_completed_total", "Jobs that finished executing, by language.", "language")
+
+	// JobsFailed counts finished jobs that did not succeed, by reason (see
+	// the Reason constants above).
+	JobsFailed = Default.NewCounter("goxec_jobs_failed_total", "Jobs that failed, by reason.", "reason")
+
+	// QueueWait measures how long a job sat queued before a worker started it.
+	QueueWait = Default.NewHistogram("goxec_queue_wait_seconds", "Time a job spent queued before a worker started it.", nil)
+
+	// ExecutionTime measures container execution time, by language.
+	ExecutionTime = Default.NewHistogram("goxec_execution_seconds", "Container execution time, by language.", nil, "language")
+
+	// RateLimitDecisions counts rate limiter allow/deny decisions, by route
+	// and outcome ("allow" or "deny").
+	RateLimitDecisions = Default.NewCounter("goxec_ratelimit_decisions_total", "Rate limiter decisions, by route and outcome.", "route", "outcome")
+)
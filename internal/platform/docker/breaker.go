@@ -0,0 +1,112 @@
+package docker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrImageUnavailable is returned when a language's image has failed to
+// pull/create too many times recently to be worth retrying immediately.
+type ErrImageUnavailable struct {
+	Language string
+	Until    time.Time
+}
+
+func (e *ErrImageUnavailable) Error() string {
+	return fmt.Sprintf("image for language %q is circuit-broken until %s", e.Language, e.Until.Format(time.RFC3339))
+}
+
+// FailureCache tracks recent pull/create failures per key (the job's
+// language) so a known-bad image stops being retried until it cools down.
+// Implementations must be safe for concurrent use. inMemoryFailureCache is
+// the default, per-instance implementation; a Redis-backed implementation
+// can satisfy the same interface to share breaker state across worker nodes
+// instead of each one independently rediscovering the same dead image.
+type FailureCache interface {
+	// RecordFailure notes a pull/create failure for key. Once failures
+	// within the configured window reach the threshold, Check starts
+	// returning an error until the cooldown elapses.
+	RecordFailure(key string)
+	// Check returns a non-nil error (an *ErrImageUnavailable) if key is
+	// currently circuit-broken.
+	Check(key string) error
+}
+
+// breakerEntry tracks one key's recent failure timestamps and, once
+// tripped, when the circuit reopens.
+type breakerEntry struct {
+	failures  []time.Time
+	openUntil time.Time
+}
+
+// inMemoryFailureCache is a per-instance FailureCache: it is not shared
+// across worker nodes, so a different node must independently observe
+// enough failures before it also trips.
+type inMemoryFailureCache struct {
+	mu        sync.Mutex
+	entries   map[string]*breakerEntry
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+}
+
+var _ FailureCache = (*inMemoryFailureCache)(nil)
+
+// NewFailureCache returns a per-instance FailureCache that trips once a key
+// fails threshold times within window, then refuses that key for cooldown.
+func NewFailureCache(threshold int, window, cooldown time.Duration) FailureCache {
+	return &inMemoryFailureCache{
+		entries:   make(map[string]*breakerEntry),
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+	}
+}
+
+// RecordFailure notes a failure for key, trimming failures that have aged
+// out of the sliding window before deciding whether the threshold is met.
+func (c *inMemoryFailureCache) RecordFailure(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &breakerEntry{}
+		c.entries[key] = entry
+	}
+
+	cutoff := now.Add(-c.window)
+	fresh := entry.failures[:0]
+	for _, t := range entry.failures {
+		if t.After(cutoff) {
+			fresh = append(fresh, t)
+		}
+	}
+	entry.failures = append(fresh, now)
+
+	if len(entry.failures) >= c.threshold {
+		entry.openUntil = now.Add(c.cooldown)
+	}
+}
+
+// Check reports whether key is currently circuit-broken, auto-expiring the
+// entry once its cooldown has elapsed so the key gets a fresh failure budget.
+func (c *inMemoryFailureCache) Check(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || entry.openUntil.IsZero() {
+		return nil
+	}
+
+	now := time.Now()
+	if now.After(entry.openUntil) {
+		delete(c.entries, key)
+		return nil
+	}
+
+	return &ErrImageUnavailable{Language: key, Until: entry.openUntil}
+}
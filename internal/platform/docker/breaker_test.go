@@ -0,0 +1,67 @@
+package docker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailureCacheTripsAfterThreshold(t *testing.T) {
+	c := NewFailureCache(3, time.Minute, time.Minute)
+
+	if err := c.Check("python"); err != nil {
+		t.Fatalf("Check before any failures = %v, want nil", err)
+	}
+
+	c.RecordFailure("python")
+	c.RecordFailure("python")
+	if err := c.Check("python"); err != nil {
+		t.Fatalf("Check below threshold = %v, want nil", err)
+	}
+
+	c.RecordFailure("python")
+	var unavailable *ErrImageUnavailable
+	if err := c.Check("python"); !errors.As(err, &unavailable) {
+		t.Fatalf("Check at threshold = %v, want *ErrImageUnavailable", err)
+	}
+}
+
+func TestFailureCacheIsPerKey(t *testing.T) {
+	c := NewFailureCache(1, time.Minute, time.Minute)
+
+	c.RecordFailure("python")
+	if err := c.Check("python"); err == nil {
+		t.Fatal("Check(python) = nil, want tripped")
+	}
+	if err := c.Check("go"); err != nil {
+		t.Fatalf("Check(go) = %v, want nil (failures shouldn't cross keys)", err)
+	}
+}
+
+func TestFailureCacheReopensAfterCooldown(t *testing.T) {
+	c := NewFailureCache(1, time.Minute, time.Millisecond)
+
+	c.RecordFailure("python")
+	if err := c.Check("python"); err == nil {
+		t.Fatal("Check immediately after tripping = nil, want tripped")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if err := c.Check("python"); err != nil {
+		t.Fatalf("Check after cooldown = %v, want nil", err)
+	}
+}
+
+func TestFailureCacheSlidingWindowDropsOldFailures(t *testing.T) {
+	c := NewFailureCache(2, time.Millisecond, time.Minute)
+
+	c.RecordFailure("python")
+	time.Sleep(5 * time.Millisecond)
+	c.RecordFailure("python")
+
+	// The first failure aged out of the window before the second happened,
+	// so only one failure should count toward the threshold.
+	if err := c.Check("python"); err != nil {
+		t.Fatalf("Check after window-expired failure = %v, want nil", err)
+	}
+}
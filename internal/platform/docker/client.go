@@ -1,23 +1,54 @@
 package docker
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/dontdude/goxec/internal/domain"
+	"github.com/dontdude/goxec/internal/metrics"
+	"github.com/dontdude/goxec/internal/runtime"
+)
+
+// imagePulls and imagePullSeconds instrument ImagePull separately from the
+// job-level metrics.ExecutionTime recorded by worker.Pool, since a pull
+// failure or a slow registry is a Docker-client-specific concern distinct
+// from how long the container itself ran.
+var (
+	imagePulls       = metrics.Default.NewCounter("goxec_image_pulls_total", "Docker image pull attempts, by outcome.", "outcome")
+	imagePullSeconds = metrics.Default.NewHistogram("goxec_image_pull_seconds", "Time spent pulling a language's image.", nil)
+)
+
+// defaultBreakerThreshold, defaultBreakerWindow, and defaultBreakerCooldown
+// configure the circuit breaker every Client starts with: 3 pull/create
+// failures for the same language within a minute trips it for 2 minutes.
+const (
+	defaultBreakerThreshold = 3
+	defaultBreakerWindow    = 1 * time.Minute
+	defaultBreakerCooldown  = 2 * time.Minute
 )
 
 // Client wraps the official Docker SDK client.
 type Client struct {
 	cli *client.Client
+	// languages resolves a job's language to its image/cmd/sandbox profile.
+	languages *runtime.LanguageRegistry
+
+	// breaker short-circuits Run for a language whose image has recently
+	// failed to pull/create repeatedly, so a dead base image can't exhaust
+	// the worker pool's concurrency slots with jobs doomed to time out.
+	breaker FailureCache
 }
 
 // Check if Client implements domain.ContainerRunner
@@ -27,7 +58,11 @@ var _ domain.ContainerRunner = (*Client)(nil)
 // It performs a connection check (Ping) upon initialization.
 // If the Docker daemon is unreachable, the function panics to prevent the service from starting in a broken state
 // (Fail-Fast).
-func NewClient() *Client {
+//
+// languages is the registry used to resolve a job's language to its image
+// and sandbox profile; pass runtime.NewLanguageRegistry() for the built-in
+// defaults, or runtime.LoadLanguageRegistry(path) to load operator overrides.
+func NewClient(languages *runtime.LanguageRegistry) *Client {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		slog.Error("Failed to create Docker client", "error", err)
@@ -43,44 +78,79 @@ func NewClient() *Client {
 	}
 
 	slog.Info("Docker Client initialized successfully")
-	return &Client{cli: cli}
+	return &Client{
+		cli:       cli,
+		languages: languages,
+		breaker:   NewFailureCache(defaultBreakerThreshold, defaultBreakerWindow, defaultBreakerCooldown),
+	}
+}
+
+// SetFailureCache swaps the circuit breaker backing Available, e.g. for a
+// Redis-backed FailureCache shared across worker nodes instead of the
+// default per-instance one.
+func (c *Client) SetFailureCache(breaker FailureCache) {
+	c.breaker = breaker
+}
+
+// Available reports whether language's image is currently circuit-broken.
+// It satisfies worker.ImageAvailabilityChecker, letting Pool.Submit reject a
+// job before it ever occupies a concurrency slot.
+func (c *Client) Available(language string) error {
+	return c.breaker.Check(language)
 }
 
 // Run executes the provided code within an ephemeral Docker container.
-// It enforces resource limits (memory) and context cancellation.
-func (c *Client) Run(ctx context.Context, code string, language string) (string, error) {
+// The container's image, command, and sandbox profile are resolved from the
+// registered RuntimeProfile for language; unknown languages are rejected
+// before anything is pulled or created.
+func (c *Client) Run(ctx context.Context, jobID string, code string, language string, events chan<- domain.StreamEvent) (domain.JobResult, error) {
+	result := domain.JobResult{JobID: jobID, StartedAt: time.Now()}
+
+	profile, ok := c.languages.Resolve(language)
+	if !ok {
+		return domain.JobResult{}, fmt.Errorf("unsupported language: %s", language)
+	}
+
+	// 0. Bail out before touching Docker at all if this language's image is
+	// circuit-broken. worker.Pool.Submit already checks this before
+	// queueing; this is a defense for callers that invoke Run directly.
+	if err := c.breaker.Check(language); err != nil {
+		return domain.JobResult{}, err
+	}
+
 	// 1. Pull Image
-	// TODO: Extract image name resolution to a configuration or map.
-	imageName := "python:alpine"
-	
-	slog.Info("Pulling image", "image", imageName)
-	reader, err := c.cli.ImagePull(ctx, imageName, image.PullOptions{})
+	slog.Info("Pulling image", "image", profile.Image)
+	pullStart := time.Now()
+	reader, err := c.cli.ImagePull(ctx, profile.Image, image.PullOptions{})
 	if err != nil {
-		slog.Error("Failed to pull image", "image", imageName, "error", err)
-		return "", fmt.Errorf("failed to pull image: %w", err)
+		slog.Error("Failed to pull image", "image", profile.Image, "error", err)
+		c.breaker.RecordFailure(language)
+		imagePulls.Inc("failure")
+		return domain.JobResult{}, fmt.Errorf("failed to pull image: %w", err)
 	}
 	// Drain the response body to ensure the pull completes properly.
 	defer reader.Close()
 	io.Copy(io.Discard, reader)
+	imagePullSeconds.Observe(time.Since(pullStart).Seconds())
+	imagePulls.Inc("success")
 
-	// 2. Create Container with Limits
-	// Configures a hard memory limit of 512MB via Cgroups to prevent resource exhaustion.
-	// Configures PidsLimit of 64 to prevent fork bombs.
-	slog.Info("Creating container", "image", imageName)
+	// 2. Resolve the command: either inline substitution, or a file written
+	// into the container's tmpfs-mounted WorkDir for languages that compile.
+	cmd := resolveCmd(profile, code)
+
+	// 3. Create Container with the profile's locked-down HostConfig.
+	slog.Info("Creating container", "image", profile.Image, "language", language)
 	resp, err := c.cli.ContainerCreate(ctx, &container.Config{
-		Image: imageName,
-		Cmd:   []string{"python", "-c", code},
+		Image: profile.Image,
+		Cmd:   cmd,
+		User:  profile.User,
 		// Tty must be false to allow multiplexed stdout/stderr for stdcopy
 		Tty: false,
-	}, &container.HostConfig{
-		Resources: container.Resources{
-			Memory:    512 * 1024 * 1024, // 512MB
-			PidsLimit: pointInt64(64),    // Fork Bomb protection
-		},
-	}, nil, nil, "")
+	}, hostConfigFor(profile), nil, nil, "")
 	if err != nil {
 		slog.Error("Failed to create container", "error", err)
-		return "", fmt.Errorf("failed to create container: %w", err)
+		c.breaker.RecordFailure(language)
+		return domain.JobResult{}, fmt.Errorf("failed to create container: %w", err)
 	}
 
 	containerID := resp.ID
@@ -95,61 +165,106 @@ func (c *Client) Run(ctx context.Context, code string, language string) (string,
 		}
 	}()
 
-	// 3. Start Container
-	if err := c.cli.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
-		return "", fmt.Errorf("failed to start container: %w", err)
+	// 4. For compiled runtimes, write the source file into the container's
+	// tmpfs work dir before starting it.
+	if profile.RequiresFile {
+		if err := c.copySource(ctx, containerID, profile, code); err != nil {
+			return domain.JobResult{}, fmt.Errorf("failed to copy source into container: %w", err)
+		}
 	}
 
-	// 4. Wait for Execution (Blocking)
-	// We use a select channel to handle both container exit and context cancellation (timeout).
-	statusCh, errCh := c.cli.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return "", fmt.Errorf("error waiting for container: %w", err)
-		}
-	case <-statusCh:
-		// Container exited successfully (or passed execution)
-	case <-ctx.Done():
-		// Context timeout, or cancellation by user
-		return "", fmt.Errorf("execution timed out: %w", ctx.Err())
+	// 5. Start Container
+	if err := c.cli.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+		return domain.JobResult{}, fmt.Errorf("failed to start container: %w", err)
 	}
 
-	// 5. Fetch Logs
-	// We fetch both Stdout and Stderr.
-	out, err := c.cli.ContainerLogs(ctx, containerID, container.LogsOptions{
+	// 6. Attach to logs with Follow: true so output is demultiplexed as the
+	// container produces it, instead of waiting for exit and fetching once.
+	logStream, err := c.cli.ContainerLogs(ctx, containerID, container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
+		Follow:     true,
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to get logs: %w", err)
+		return domain.JobResult{}, fmt.Errorf("failed to attach to container logs: %w", err)
 	}
-	defer out.Close()
 
-	// 6. Demultiplex Logs (stdcopy)
-	// Docker streams combine stdout/stderr headers. stdcopy splits them.
-	// We use a limited buffer to prevent OOM (1MB limit).
+	// We use a limited buffer per-stream to prevent OOM (1MB limit each),
+	// wrapped so every chunk also fans out to events for live subscribers.
 	const maxLogSize = 1 * 1024 * 1024 // 1MB
 
 	stdoutBuf := &limitedBuffer{buf: new(bytes.Buffer), limit: maxLogSize}
 	stderrBuf := &limitedBuffer{buf: new(bytes.Buffer), limit: maxLogSize}
 
-	if _, err := stdcopy.StdCopy(stdoutBuf, stderrBuf, out); err != nil {
+	var seq int64
+	stdoutW := &streamWriter{limited: stdoutBuf, jobID: jobID, kind: "stdout", events: events, seq: &seq}
+	stderrW := &streamWriter{limited: stderrBuf, jobID: jobID, kind: "stderr", events: events, seq: &seq}
+
+	copyDone := make(chan error, 1)
+	go func() {
+		_, copyErr := stdcopy.StdCopy(stdoutW, stderrW, logStream)
+		copyDone <- copyErr
+	}()
+
+	// 7. Wait for Execution (Blocking)
+	// We use a select channel to handle both container exit and context cancellation (timeout).
+	statusCh, errCh := c.cli.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		logStream.Close()
+		<-copyDone
+		if err != nil {
+			return domain.JobResult{}, fmt.Errorf("error waiting for container: %w", err)
+		}
+	case status := <-statusCh:
+		result.ExitCode = int(status.StatusCode)
+		logStream.Close()
+	case <-ctx.Done():
+		// Context timeout, or cancellation by user. The container keeps
+		// running until the deferred Force-remove above kills it.
+		logStream.Close()
+		<-copyDone
+		result.TimedOut = true
+		result.FinishedAt = time.Now()
+		result.DurationMs = result.FinishedAt.Sub(result.StartedAt).Milliseconds()
+		result.Error = fmt.Sprintf("execution timed out: %v", ctx.Err())
+		return result, nil
+	}
+
+	// 7b. Inspect the container to tell an OOM kill apart from a normal exit.
+	if inspect, err := c.cli.ContainerInspect(ctx, containerID); err != nil {
+		slog.Error("Failed to inspect container", "containerID", containerID, "error", err)
+	} else {
+		result.OOMKilled = inspect.State.OOMKilled
+	}
+
+	// 8. Drain the demultiplexing goroutine now that the log stream is closed.
+	if copyErr := <-copyDone; copyErr != nil {
 		// Ignore limit "errors" as they are just execution limits, not system failures.
 		// Real system failures from stdcopy are rare but should be logged.
-		if !errors.Is(err, errLogLimitExceeded) {
-			return "", fmt.Errorf("failed to demultiplex logs: %w", err)
+		if !errors.Is(copyErr, errLogLimitExceeded) {
+			return domain.JobResult{}, fmt.Errorf("failed to demultiplex logs: %w", copyErr)
 		}
 		slog.Warn("Log limit exceeded", "containerID", containerID)
 	}
 
-	return stdoutBuf.String() + stderrBuf.String(), nil
+	result.Stdout = stdoutBuf.String()
+	result.Stderr = stderrBuf.String()
+	result.TruncatedStdout = stdoutBuf.truncated
+	result.TruncatedStderr = stderrBuf.truncated
+	result.FinishedAt = time.Now()
+	result.DurationMs = result.FinishedAt.Sub(result.StartedAt).Milliseconds()
+
+	emitStreamEvent(events, &seq, jobID, "exit", []byte(strconv.Itoa(result.ExitCode)))
+
+	return result, nil
 }
 
 // limitedBuffer is a custom writer that enforces a hard size limit.
 type limitedBuffer struct {
-	buf   *bytes.Buffer
-	limit int
+	buf       *bytes.Buffer
+	limit     int
+	truncated bool
 }
 
 // errLogLimitExceeded is the sentinel error when logs are truncated.
@@ -163,6 +278,7 @@ func (l *limitedBuffer) Write(p []byte) (n int, err error) {
 			l.buf.Write(p[:remaining])
 			l.buf.WriteString("\n<LOG TRUNCATED>")
 		}
+		l.truncated = true
 		return remaining, errLogLimitExceeded
 	}
 	return l.buf.Write(p)
@@ -172,7 +288,115 @@ func (l *limitedBuffer) String() string {
 	return l.buf.String()
 }
 
+// streamWriter wraps a limitedBuffer so every chunk written to it (i.e. every
+// demultiplexed read from the container's log stream) also fans out as a
+// domain.StreamEvent to live subscribers, without slowing down the copy loop:
+// a full events channel drops the chunk rather than blocking the container.
+type streamWriter struct {
+	limited *limitedBuffer
+	jobID   string
+	kind    string
+	events  chan<- domain.StreamEvent
+	seq     *int64
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	n, err := w.limited.Write(p)
+	if n > 0 {
+		chunk := make([]byte, n)
+		copy(chunk, p[:n])
+		emitStreamEvent(w.events, w.seq, w.jobID, w.kind, chunk)
+	}
+	return n, err
+}
+
+// emitStreamEvent publishes a StreamEvent if events is non-nil, dropping the
+// event instead of blocking when the channel's buffer is full.
+func emitStreamEvent(events chan<- domain.StreamEvent, seq *int64, jobID, kind string, data []byte) {
+	if events == nil {
+		return
+	}
+	select {
+	case events <- domain.StreamEvent{JobID: jobID, Kind: kind, Data: data, Seq: atomic.AddInt64(seq, 1)}:
+	default:
+		slog.Warn("Dropping stream event, subscriber channel full", "jobID", jobID, "kind", kind)
+	}
+}
+
 // Helper to get a pointer to an int64 (needed for HostConfig)
 func pointInt64(i int64) *int64 {
 	return &i
 }
+
+// resolveCmd substitutes "{{CODE}}" in the profile's command template with
+// the job's source. Compiled runtimes ignore the template's code slot since
+// the source is written to a file by copySource instead.
+func resolveCmd(profile runtime.RuntimeProfile, code string) []string {
+	if profile.RequiresFile {
+		return profile.Cmd
+	}
+
+	cmd := make([]string, len(profile.Cmd))
+	for i, arg := range profile.Cmd {
+		if arg == "{{CODE}}" {
+			arg = code
+		}
+		cmd[i] = arg
+	}
+	return cmd
+}
+
+// hostConfigFor translates a RuntimeProfile into the locked-down
+// container.HostConfig used to create the sandbox.
+func hostConfigFor(profile runtime.RuntimeProfile) *container.HostConfig {
+	tmpfs := make(map[string]string, len(profile.TmpfsMounts))
+	for path, opts := range profile.TmpfsMounts {
+		tmpfs[path] = opts
+	}
+
+	return &container.HostConfig{
+		Resources: container.Resources{
+			Memory:    profile.MemoryBytes,
+			PidsLimit: pointInt64(profile.PidsLimit),
+			CPUQuota:  profile.CPUQuota,
+		},
+		NetworkMode:    container.NetworkMode(profile.NetworkMode),
+		ReadonlyRootfs: profile.ReadOnlyRootFS,
+		CapDrop:        profile.CapDrop,
+		Tmpfs:          tmpfs,
+		SecurityOpt:    seccompOpt(profile.SeccompProfile),
+	}
+}
+
+// seccompOpt builds the --security-opt value for a seccomp profile path, or
+// nil to fall back to the Docker daemon's default profile.
+func seccompOpt(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return []string{"seccomp=" + path}
+}
+
+// copySource writes code into the container's WorkDir before it starts,
+// using a tar stream as required by the Docker API's CopyToContainer.
+func (c *Client) copySource(ctx context.Context, containerID string, profile runtime.RuntimeProfile, code string) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	fileName := "main" + profile.FileExtension
+	if err := tw.WriteHeader(&tar.Header{
+		Name: fileName,
+		Mode: 0644,
+		Size: int64(len(code)),
+	}); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+	if _, err := tw.Write([]byte(code)); err != nil {
+		return fmt.Errorf("failed to write source to tar: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+
+	return c.cli.CopyToContainer(ctx, containerID, profile.WorkDir, &buf, container.CopyToContainerOptions{})
+}
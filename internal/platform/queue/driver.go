@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dontdude/goxec/internal/domain"
+)
+
+// Driver is the full surface a queue backend must implement to be usable as
+// the application's job queue: domain.JobQueue plus the result-broadcast and
+// self-healing behaviors cmd/server and cmd/worker wire up around it.
+type Driver interface {
+	domain.JobQueue
+
+	// Broadcast publishes a finished job's result for live log tailers.
+	Broadcast(ctx context.Context, result domain.JobResult) error
+	// SubscribeLogs streams every broadcast result to a Go channel.
+	SubscribeLogs(ctx context.Context) (<-chan domain.JobResult, error)
+
+	// StartRecoveryRoutine reclaims jobs stuck in-flight for longer than
+	// maxAge, dead-lettering them once they exceed their retry budget. It
+	// blocks until ctx is cancelled.
+	StartRecoveryRoutine(ctx context.Context, interval time.Duration, maxAge time.Duration)
+}
+
+var (
+	_ Driver = (*RedisQueue)(nil)
+	_ Driver = (*MemoryQueue)(nil)
+	_ Driver = (*JetStreamQueue)(nil)
+	_ Driver = (*KafkaQueue)(nil)
+)
+
+// Config collects the settings any driver might need. Drivers ignore the
+// fields that don't apply to them.
+type Config struct {
+	// Addr is the broker address: a Redis "host:port", a NATS URL, or a
+	// Kafka bootstrap server list, depending on Driver.
+	Addr string
+	// Stream/Group name the job queue and its grouping: a Redis Stream and
+	// its consumer group, a JetStream subject and the stream holding it, or
+	// a Kafka topic and consumer group.
+	Stream string
+	Group  string
+}
+
+// New constructs the Driver selected by driver ("redis", "memory", "nats",
+// or "kafka"). Unknown drivers are a configuration error, not a panic, so
+// callers can decide how to fail.
+func New(driver string, cfg Config) (Driver, error) {
+	switch driver {
+	case "redis":
+		return NewRedisQueue(cfg.Addr, cfg.Stream, cfg.Group)
+	case "memory":
+		return NewMemoryQueue(), nil
+	case "nats":
+		return NewJetStreamQueue(cfg.Addr, cfg.Stream, cfg.Group)
+	case "kafka":
+		return NewKafkaQueue(cfg.Addr, cfg.Stream, cfg.Group)
+	default:
+		return nil, fmt.Errorf("unknown queue driver %q (want redis, memory, nats, or kafka)", driver)
+	}
+}
+
+// NewFromEnv builds the Driver selected by GOXEC_QUEUE_DRIVER (default
+// "redis"), at the address in GOXEC_QUEUE_ADDR (default defaultAddr), with
+// the given stream and group.
+func NewFromEnv(defaultAddr, stream, group string) (Driver, error) {
+	driver := os.Getenv("GOXEC_QUEUE_DRIVER")
+	if driver == "" {
+		driver = "redis"
+	}
+
+	addr := os.Getenv("GOXEC_QUEUE_ADDR")
+	if addr == "" {
+		addr = defaultAddr
+	}
+
+	return New(driver, Config{Addr: addr, Stream: stream, Group: group})
+}
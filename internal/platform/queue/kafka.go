@@ -0,0 +1,321 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dontdude/goxec/internal/domain"
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaQueue implements domain.JobQueue on top of Kafka, using a consumer
+// group for load-balanced delivery and a companion "<topic>.dead" topic for
+// dead-lettering. Kafka has no native PEL/retry-count like Redis Streams or
+// JetStream; the message's own JSON envelope carries a DeliveryCount, but
+// Publish always writes it as zero and nothing in this driver rewrites it,
+// so redelivered jobs report a DeliveryCount of zero.
+type KafkaQueue struct {
+	writer *kafka.Writer
+	reader *kafka.Reader
+
+	deadWriter *kafka.Writer
+
+	topic      string
+	group      string
+	maxRetries int64
+
+	// pendingMu guards pending, the map from a delivered job's RawID back to
+	// the kafka.Message handle CommitMessages needs. kafka-go only commits
+	// by message handle, not by the partition:offset pair alone, so Subscribe
+	// stashes each handle here and Acknowledge/DeadLetter look it up and
+	// remove it once the message is resolved one way or the other.
+	pendingMu sync.Mutex
+	pending   map[string]kafka.Message
+}
+
+var _ domain.JobQueue = (*KafkaQueue)(nil)
+
+// NewKafkaQueue dials brokerAddr (a comma-separated bootstrap server list)
+// and sets up the topic's writer/reader and dead-letter writer. It fails
+// fast with a returned error if the brokers are unreachable.
+func NewKafkaQueue(brokerAddr, topic, group string) (*KafkaQueue, error) {
+	brokers := splitBrokers(brokerAddr)
+
+	conn, err := kafka.DialContext(context.Background(), "tcp", brokers[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to kafka: %w", err)
+	}
+	conn.Close()
+
+	writer := &kafka.Writer{
+		Addr:     kafka.TCP(brokers...),
+		Topic:    topic,
+		Balancer: &kafka.LeastBytes{},
+	}
+	deadWriter := &kafka.Writer{
+		Addr:     kafka.TCP(brokers...),
+		Topic:    topic + ".dead",
+		Balancer: &kafka.LeastBytes{},
+	}
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		Topic:   topic,
+		GroupID: group,
+		// Commits are driven explicitly by Acknowledge, not on every read, so
+		// an unacked job is redelivered to the group after a consumer dies.
+	})
+
+	return &KafkaQueue{
+		writer:     writer,
+		reader:     reader,
+		deadWriter: deadWriter,
+		topic:      topic,
+		group:      group,
+		maxRetries: defaultMaxRetries,
+		pending:    make(map[string]kafka.Message),
+	}, nil
+}
+
+// splitBrokers splits a comma-separated bootstrap server list.
+func splitBrokers(addr string) []string {
+	var brokers []string
+	start := 0
+	for i := 0; i <= len(addr); i++ {
+		if i == len(addr) || addr[i] == ',' {
+			if i > start {
+				brokers = append(brokers, addr[start:i])
+			}
+			start = i + 1
+		}
+	}
+	return brokers
+}
+
+// SetMaxRetries records n as the default delivery-count ceiling. Nothing in
+// this driver reads it: KafkaQueue never dead-letters on its own.
+func (q *KafkaQueue) SetMaxRetries(n int64) {
+	q.maxRetries = n
+}
+
+// kafkaEnvelope wraps a job with a DeliveryCount field, which Kafka itself
+// doesn't track. Publish writes it as zero and it is not updated on
+// redelivery.
+type kafkaEnvelope struct {
+	Job           domain.Job `json:"job"`
+	DeliveryCount int64      `json:"delivery_count"`
+}
+
+// Publish writes job to the topic. Kafka has no SETNX-style primitive, so
+// IdempotencyKey is used only as the message key: distinct calls with the
+// same key are not de-duplicated the way RedisQueue's SETNX does, and
+// Publish always returns job.ID.
+func (q *KafkaQueue) Publish(ctx context.Context, job domain.Job) (string, error) {
+	data, err := json.Marshal(kafkaEnvelope{Job: job})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	msg := kafka.Message{Value: data}
+	if job.IdempotencyKey != "" {
+		msg.Key = []byte(job.IdempotencyKey)
+	}
+
+	if err := q.writer.WriteMessages(ctx, msg); err != nil {
+		return "", fmt.Errorf("kafka publish failed: %w", err)
+	}
+	return job.ID, nil
+}
+
+// Subscribe reads messages from the consumer group and streams jobs to a Go
+// channel. RawID is the "partition:offset" pair under which the message's
+// kafka.Message handle is stashed for Acknowledge to commit later.
+func (q *KafkaQueue) Subscribe(ctx context.Context) (<-chan domain.Job, error) {
+	outCh := make(chan domain.Job)
+
+	go func() {
+		defer close(outCh)
+		for {
+			msg, err := q.reader.FetchMessage(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Error("Kafka read error", "error", err)
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var env kafkaEnvelope
+			if err := json.Unmarshal(msg.Value, &env); err != nil {
+				slog.Error("Failed to unmarshal job", "error", err)
+				continue
+			}
+
+			job := env.Job
+			job.DeliveryCount = env.DeliveryCount
+			job.RawID = fmt.Sprintf("%d:%d", msg.Partition, msg.Offset)
+			job.Consumer = q.group
+
+			q.pendingMu.Lock()
+			q.pending[job.RawID] = msg
+			q.pendingMu.Unlock()
+
+			select {
+			case outCh <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return outCh, nil
+}
+
+// Acknowledge commits rawID's offset via CommitMessages, using the
+// kafka.Message handle Subscribe stashed for it (partition+offset alone
+// isn't enough to reconstruct one). Without this, offsets never advance and
+// every worker restart or rebalance redelivers and re-executes every job
+// the group has ever processed.
+func (q *KafkaQueue) Acknowledge(ctx context.Context, rawID string) error {
+	msg, err := q.takePending(rawID)
+	if err != nil {
+		return err
+	}
+	return q.reader.CommitMessages(ctx, msg)
+}
+
+// takePending looks up and removes rawID's stashed kafka.Message handle,
+// shared by Acknowledge and DeadLetter so both ultimately commit the
+// offset once the job is resolved.
+func (q *KafkaQueue) takePending(rawID string) (kafka.Message, error) {
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+
+	msg, ok := q.pending[rawID]
+	if !ok {
+		return kafka.Message{}, fmt.Errorf("kafka: no pending message handle for %s (already acked, or delivered before a restart)", rawID)
+	}
+	delete(q.pending, rawID)
+	return msg, nil
+}
+
+// DeadLetter writes job to the topic's dead-letter topic, annotated with
+// reason, then commits the original message's offset so it stops being
+// redelivered by the consumer group now that it's been dead-lettered.
+func (q *KafkaQueue) DeadLetter(ctx context.Context, job domain.Job, reason string) error {
+	entry := deadJobEntry{
+		Job:              job,
+		Reason:           reason,
+		OriginalStreamID: job.RawID,
+		Consumer:         job.Consumer,
+		DeadLetteredAt:   time.Now(),
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead job: %w", err)
+	}
+
+	if err := q.deadWriter.WriteMessages(ctx, kafka.Message{Value: data}); err != nil {
+		return fmt.Errorf("failed to write dead-letter message: %w", err)
+	}
+
+	if job.RawID == "" {
+		return nil
+	}
+	msg, err := q.takePending(job.RawID)
+	if err != nil {
+		return fmt.Errorf("failed to commit dead-lettered job's offset: %w", err)
+	}
+	return q.reader.CommitMessages(ctx, msg)
+}
+
+// ListDead is not yet implemented for the Kafka driver: reading "most recent
+// N" back out of a topic requires seeking from the high watermark on every
+// partition, which needs its own reader/offset bookkeeping distinct from the
+// main consumer group.
+func (q *KafkaQueue) ListDead(ctx context.Context, limit int64) ([]domain.DeadJob, error) {
+	return nil, fmt.Errorf("kafka: listing dead-lettered jobs is not yet supported")
+}
+
+// RequeueDead is not yet implemented for the Kafka driver, for the same
+// reason as ListDead.
+func (q *KafkaQueue) RequeueDead(ctx context.Context, id string) error {
+	return fmt.Errorf("kafka: requeueing a dead-lettered job by id is not yet supported")
+}
+
+// StoreResult and Result are not backed by Kafka itself (it has no
+// convenient key/value-with-TTL primitive); a production deployment would
+// pair Kafka with Redis or another store for result caching here.
+func (q *KafkaQueue) StoreResult(ctx context.Context, result domain.JobResult) error {
+	return fmt.Errorf("kafka: result caching requires a separate key/value store, not yet wired up")
+}
+
+func (q *KafkaQueue) Result(ctx context.Context, jobID string) (domain.JobResult, bool, error) {
+	return domain.JobResult{}, false, fmt.Errorf("kafka: result caching requires a separate key/value store, not yet wired up")
+}
+
+// Broadcast writes result to the group's log topic for live tailers.
+func (q *KafkaQueue) Broadcast(ctx context.Context, result domain.JobResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log: %w", err)
+	}
+
+	logWriter := &kafka.Writer{Addr: q.writer.Addr, Topic: q.group + ".logs", Balancer: &kafka.LeastBytes{}}
+	defer logWriter.Close()
+	return logWriter.WriteMessages(ctx, kafka.Message{Value: data})
+}
+
+// SubscribeLogs subscribes to the group's log topic and streams results to a
+// Go channel.
+func (q *KafkaQueue) SubscribeLogs(ctx context.Context) (<-chan domain.JobResult, error) {
+	logReader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: q.reader.Config().Brokers,
+		Topic:   q.group + ".logs",
+		GroupID: q.group + "-logs",
+	})
+
+	outCh := make(chan domain.JobResult)
+
+	go func() {
+		defer close(outCh)
+		defer logReader.Close()
+
+		for {
+			msg, err := logReader.ReadMessage(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Error("Kafka log read error", "error", err)
+				continue
+			}
+
+			var result domain.JobResult
+			if err := json.Unmarshal(msg.Value, &result); err != nil {
+				slog.Error("Failed to unmarshal log", "error", err)
+				continue
+			}
+
+			select {
+			case outCh <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return outCh, nil
+}
+
+// StartRecoveryRoutine is a no-op for Kafka: the consumer group's own
+// session/heartbeat timeout already reassigns a dead consumer's partitions,
+// and there is no client-addressable PEL to sweep the way Redis Streams has.
+func (q *KafkaQueue) StartRecoveryRoutine(ctx context.Context, interval time.Duration, maxAge time.Duration) {
+	slog.Info("Kafka recovery is handled by consumer-group rebalancing; recovery routine is a no-op", "interval", interval, "maxAge", maxAge)
+	<-ctx.Done()
+}
@@ -0,0 +1,329 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dontdude/goxec/internal/domain"
+)
+
+// memoryConsumer is the consumer name MemoryQueue's recovery routine reclaims
+// stale jobs under, mirroring RedisQueue's recoveryConsumer.
+const memoryConsumer = "recovery-agent"
+
+// pendingEntry tracks a job that has been delivered to a consumer but not
+// yet acknowledged, simulating a Redis Streams Pending Entry List (PEL)
+// entry well enough for the worker pool's ack/retry/dead-letter bookkeeping.
+type pendingEntry struct {
+	job         domain.Job
+	deliveredAt time.Time
+}
+
+// MemoryQueue implements domain.JobQueue with buffered Go channels and a
+// mutex-guarded PEL simulation, with no external dependencies. It exists so
+// the worker pool (and anything built on domain.JobQueue) can be exercised
+// in unit tests without a live Redis.
+type MemoryQueue struct {
+	mu sync.Mutex
+
+	jobsCh chan domain.Job
+
+	// pending simulates the PEL: jobs delivered to a consumer but not yet
+	// acknowledged, keyed by the synthetic RawID assigned at delivery.
+	pending map[string]*pendingEntry
+	nextID  int64
+
+	dead       []domain.DeadJob
+	nextDeadID int64
+
+	idem    map[string]string
+	idemExp map[string]time.Time
+
+	results    map[string]domain.JobResult
+	resultsExp map[string]time.Time
+
+	logSubs map[int]chan domain.JobResult
+	nextSub int
+
+	maxRetries int64
+}
+
+var _ domain.JobQueue = (*MemoryQueue)(nil)
+
+// NewMemoryQueue returns an empty in-memory queue. The channel buffer is
+// generous (1024) since, unlike Redis, a full buffer would block Publish
+// rather than just growing the stream.
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		jobsCh:     make(chan domain.Job, 1024),
+		pending:    make(map[string]*pendingEntry),
+		idem:       make(map[string]string),
+		idemExp:    make(map[string]time.Time),
+		results:    make(map[string]domain.JobResult),
+		resultsExp: make(map[string]time.Time),
+		logSubs:    make(map[int]chan domain.JobResult),
+		maxRetries: defaultMaxRetries,
+	}
+}
+
+// SetMaxRetries overrides the default delivery-count ceiling, mirroring
+// RedisQueue.SetMaxRetries.
+func (q *MemoryQueue) SetMaxRetries(n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.maxRetries = n
+}
+
+// Publish enqueues job, honoring IdempotencyKey the same way RedisQueue does.
+func (q *MemoryQueue) Publish(ctx context.Context, job domain.Job) (string, error) {
+	if job.IdempotencyKey != "" {
+		q.mu.Lock()
+		if existing, ok := q.idem[job.IdempotencyKey]; ok && time.Now().Before(q.idemExp[job.IdempotencyKey]) {
+			q.mu.Unlock()
+			return existing, nil
+		}
+		q.idem[job.IdempotencyKey] = job.ID
+		q.idemExp[job.IdempotencyKey] = time.Now().Add(idemKeyTTL)
+		q.mu.Unlock()
+	}
+
+	select {
+	case q.jobsCh <- job:
+		return job.ID, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Subscribe returns a channel of jobs, assigning each a synthetic RawID and
+// tracking it as pending until Acknowledge or DeadLetter clears it.
+func (q *MemoryQueue) Subscribe(ctx context.Context) (<-chan domain.Job, error) {
+	outCh := make(chan domain.Job)
+
+	go func() {
+		defer close(outCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case job, ok := <-q.jobsCh:
+				if !ok {
+					return
+				}
+
+				if job.RawID == "" {
+					job.RawID = fmt.Sprintf("mem-%d", atomic.AddInt64(&q.nextID, 1))
+				}
+
+				q.mu.Lock()
+				q.pending[job.RawID] = &pendingEntry{job: job, deliveredAt: time.Now()}
+				q.mu.Unlock()
+
+				select {
+				case outCh <- job:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return outCh, nil
+}
+
+// Acknowledge clears rawID from the pending set.
+func (q *MemoryQueue) Acknowledge(ctx context.Context, rawID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.pending, rawID)
+	return nil
+}
+
+// DeadLetter removes job from the pending set (if present) and appends it to
+// the in-memory dead-letter list.
+func (q *MemoryQueue) DeadLetter(ctx context.Context, job domain.Job, reason string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.pending, job.RawID)
+
+	q.nextDeadID++
+	q.dead = append(q.dead, domain.DeadJob{
+		ID:               fmt.Sprintf("mem-dead-%d", q.nextDeadID),
+		Job:              job,
+		Reason:           reason,
+		OriginalStreamID: job.RawID,
+		Consumer:         job.Consumer,
+		DeadLetteredAt:   time.Now(),
+	})
+	return nil
+}
+
+// ListDead returns up to limit dead-lettered jobs, most recent first.
+func (q *MemoryQueue) ListDead(ctx context.Context, limit int64) ([]domain.DeadJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := int64(len(q.dead))
+	if limit > 0 && limit < n {
+		n = limit
+	}
+
+	out := make([]domain.DeadJob, n)
+	for i := range out {
+		out[i] = q.dead[len(q.dead)-1-int(i)]
+	}
+	return out, nil
+}
+
+// RequeueDead re-publishes a dead-lettered job by its dead-list ID and
+// removes it from the dead-letter list.
+func (q *MemoryQueue) RequeueDead(ctx context.Context, id string) error {
+	q.mu.Lock()
+	idx := -1
+	for i, d := range q.dead {
+		if d.ID == id {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		q.mu.Unlock()
+		return fmt.Errorf("dead-letter entry %s not found", id)
+	}
+	entry := q.dead[idx]
+	q.dead = append(q.dead[:idx], q.dead[idx+1:]...)
+	q.mu.Unlock()
+
+	entry.Job.RawID = ""
+	entry.Job.DeliveryCount = 0
+	entry.Job.LastError = ""
+	entry.Job.IdempotencyKey = ""
+
+	_, err := q.Publish(ctx, entry.Job)
+	return err
+}
+
+// StoreResult caches result in memory for resultTTL.
+func (q *MemoryQueue) StoreResult(ctx context.Context, result domain.JobResult) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.results[result.JobID] = result
+	q.resultsExp[result.JobID] = time.Now().Add(resultTTL)
+	return nil
+}
+
+// Result returns a cached result for jobID, if any and not expired.
+func (q *MemoryQueue) Result(ctx context.Context, jobID string) (domain.JobResult, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	result, ok := q.results[jobID]
+	if !ok || time.Now().After(q.resultsExp[jobID]) {
+		return domain.JobResult{}, false, nil
+	}
+	return result, true, nil
+}
+
+// Broadcast fans result out to every active SubscribeLogs channel.
+func (q *MemoryQueue) Broadcast(ctx context.Context, result domain.JobResult) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, ch := range q.logSubs {
+		select {
+		case ch <- result:
+		default:
+			slog.Warn("Dropping broadcast result, subscriber channel full", "jobID", result.JobID)
+		}
+	}
+	return nil
+}
+
+// SubscribeLogs returns a channel that receives every future Broadcast result.
+func (q *MemoryQueue) SubscribeLogs(ctx context.Context) (<-chan domain.JobResult, error) {
+	ch := make(chan domain.JobResult, 16)
+
+	q.mu.Lock()
+	id := q.nextSub
+	q.nextSub++
+	q.logSubs[id] = ch
+	q.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		q.mu.Lock()
+		delete(q.logSubs, id)
+		q.mu.Unlock()
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// StartRecoveryRoutine periodically reclaims pending jobs idle for longer
+// than maxAge: jobs within their retry budget are redelivered, jobs past it
+// are dead-lettered. It blocks until ctx is cancelled.
+func (q *MemoryQueue) StartRecoveryRoutine(ctx context.Context, interval time.Duration, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	slog.Info("Starting in-memory recovery routine", "interval", interval, "maxAge", maxAge)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.reclaimStale(ctx, maxAge)
+		}
+	}
+}
+
+func (q *MemoryQueue) reclaimStale(ctx context.Context, maxAge time.Duration) {
+	now := time.Now()
+
+	q.mu.Lock()
+	var stale []*pendingEntry
+	for rawID, entry := range q.pending {
+		if now.Sub(entry.deliveredAt) >= maxAge {
+			stale = append(stale, entry)
+			delete(q.pending, rawID)
+		}
+	}
+	maxRetries := q.maxRetries
+	q.mu.Unlock()
+
+	for _, entry := range stale {
+		job := entry.job
+		job.DeliveryCount++
+		job.Consumer = memoryConsumer
+
+		retryBudget := maxRetries
+		if job.MaxRetries > 0 {
+			retryBudget = job.MaxRetries
+		}
+
+		if job.DeliveryCount > retryBudget {
+			reason := fmt.Sprintf("exceeded max retries (%d/%d) while idle, last claimed by %s", job.DeliveryCount, retryBudget, memoryConsumer)
+			if err := q.DeadLetter(ctx, job, reason); err != nil {
+				slog.Error("Failed to dead-letter stale job", "jobID", job.ID, "error", err)
+			}
+			slog.Warn("Stale job dead-lettered after exceeding retries", "jobID", job.ID, "deliveryCount", job.DeliveryCount)
+			continue
+		}
+
+		slog.Warn("Stale job reclaimed, redelivering", "jobID", job.ID, "deliveryCount", job.DeliveryCount)
+		job.RawID = ""
+		// Redelivery is an internal retry, not a new client submission, so it
+		// must not be deduplicated against the original idempotency key.
+		job.IdempotencyKey = ""
+		if _, err := q.Publish(ctx, job); err != nil {
+			slog.Error("Failed to redeliver stale job", "jobID", job.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dontdude/goxec/internal/domain"
+)
+
+// recvJob waits up to a short timeout for a job on ch, failing the test if
+// none arrives.
+func recvJob(t *testing.T, ch <-chan domain.Job) domain.Job {
+	t.Helper()
+	select {
+	case job := <-ch:
+		return job
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for job")
+		return domain.Job{}
+	}
+}
+
+func TestMemoryQueuePublishSubscribeAcknowledge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := NewMemoryQueue()
+
+	jobsCh, err := q.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	if _, err := q.Publish(ctx, domain.Job{ID: "job-1"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	job := recvJob(t, jobsCh)
+	if job.ID != "job-1" {
+		t.Fatalf("got job ID %q, want job-1", job.ID)
+	}
+	if job.RawID == "" {
+		t.Fatal("expected Subscribe to assign a RawID")
+	}
+
+	if err := q.Acknowledge(ctx, job.RawID); err != nil {
+		t.Fatalf("Acknowledge: %v", err)
+	}
+
+	q.mu.Lock()
+	_, stillPending := q.pending[job.RawID]
+	q.mu.Unlock()
+	if stillPending {
+		t.Fatal("Acknowledge should have cleared the pending entry")
+	}
+}
+
+func TestMemoryQueuePublishIdempotencyKeyDeduplicates(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue()
+
+	first, err := q.Publish(ctx, domain.Job{ID: "job-1", IdempotencyKey: "key-a"})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	second, err := q.Publish(ctx, domain.Job{ID: "job-2", IdempotencyKey: "key-a"})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	if second != first {
+		t.Fatalf("duplicate submission under the same idempotency key returned %q, want %q", second, first)
+	}
+}
+
+func TestMemoryQueueDeadLetterAndRequeueDead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := NewMemoryQueue()
+
+	jobsCh, err := q.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	if _, err := q.Publish(ctx, domain.Job{ID: "job-1"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	job := recvJob(t, jobsCh)
+
+	if err := q.DeadLetter(ctx, job, "boom"); err != nil {
+		t.Fatalf("DeadLetter: %v", err)
+	}
+
+	dead, err := q.ListDead(ctx, 10)
+	if err != nil {
+		t.Fatalf("ListDead: %v", err)
+	}
+	if len(dead) != 1 || dead[0].Reason != "boom" {
+		t.Fatalf("ListDead = %+v, want one entry with reason %q", dead, "boom")
+	}
+
+	if err := q.RequeueDead(ctx, dead[0].ID); err != nil {
+		t.Fatalf("RequeueDead: %v", err)
+	}
+
+	requeued := recvJob(t, jobsCh)
+	if requeued.ID != "job-1" {
+		t.Fatalf("got requeued job ID %q, want job-1", requeued.ID)
+	}
+	if requeued.DeliveryCount != 0 {
+		t.Fatalf("requeued job DeliveryCount = %d, want 0 (fresh retry budget)", requeued.DeliveryCount)
+	}
+
+	dead, err = q.ListDead(ctx, 10)
+	if err != nil {
+		t.Fatalf("ListDead after requeue: %v", err)
+	}
+	if len(dead) != 0 {
+		t.Fatalf("ListDead after requeue = %+v, want empty", dead)
+	}
+}
+
+func TestMemoryQueueReclaimStaleRedeliversWithinBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := NewMemoryQueue()
+	q.SetMaxRetries(2)
+
+	jobsCh, err := q.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	if _, err := q.Publish(ctx, domain.Job{ID: "job-1"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	first := recvJob(t, jobsCh)
+
+	// Backdate delivery so reclaimStale treats it as idle without sleeping.
+	q.mu.Lock()
+	q.pending[first.RawID].deliveredAt = time.Now().Add(-time.Minute)
+	q.mu.Unlock()
+
+	q.reclaimStale(ctx, time.Second)
+
+	redelivered := recvJob(t, jobsCh)
+	if redelivered.DeliveryCount != 1 {
+		t.Fatalf("redelivered job DeliveryCount = %d, want 1", redelivered.DeliveryCount)
+	}
+
+	dead, err := q.ListDead(ctx, 10)
+	if err != nil {
+		t.Fatalf("ListDead: %v", err)
+	}
+	if len(dead) != 0 {
+		t.Fatalf("job within retry budget should not be dead-lettered, got %+v", dead)
+	}
+}
+
+func TestMemoryQueueReclaimStaleDeadLettersPastBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := NewMemoryQueue()
+	q.SetMaxRetries(0)
+
+	jobsCh, err := q.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	if _, err := q.Publish(ctx, domain.Job{ID: "job-1"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	first := recvJob(t, jobsCh)
+
+	q.mu.Lock()
+	q.pending[first.RawID].deliveredAt = time.Now().Add(-time.Minute)
+	q.mu.Unlock()
+
+	q.reclaimStale(ctx, time.Second)
+
+	dead, err := q.ListDead(ctx, 10)
+	if err != nil {
+		t.Fatalf("ListDead: %v", err)
+	}
+	if len(dead) != 1 {
+		t.Fatalf("job past retry budget should be dead-lettered, got %+v", dead)
+	}
+
+	select {
+	case job := <-jobsCh:
+		t.Fatalf("job past retry budget should not be redelivered, got %+v", job)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
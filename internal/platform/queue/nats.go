@@ -0,0 +1,333 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dontdude/goxec/internal/domain"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// jetStreamConsumer is the durable pull consumer name every worker process
+// pulls from, mirroring RedisQueue's shared consumer group.
+const jetStreamConsumer = "goxec-workers"
+
+// JetStreamQueue implements domain.JobQueue on top of NATS JetStream, using
+// a durable pull consumer with AckExplicit and MaxDeliver so redelivery and
+// dead-lettering behave the same way RedisQueue's consumer-group PEL does.
+type JetStreamQueue struct {
+	nc *nats.Conn
+	js jetstream.JetStream
+
+	stream   jetstream.Stream
+	consumer jetstream.Consumer
+
+	subject     string
+	deadSubject string
+	group       string
+
+	maxRetries int64
+
+	// pendingMu guards pending, the map from a delivered job's RawID (its
+	// stream sequence) back to the jetstream.Msg handle Ack requires.
+	// JetStream acks by message handle, not by sequence number alone, so
+	// Subscribe's consume callback stashes each handle here and
+	// Acknowledge/DeadLetter look it up and remove it once resolved.
+	pendingMu sync.Mutex
+	pending   map[string]jetstream.Msg
+}
+
+var _ domain.JobQueue = (*JetStreamQueue)(nil)
+
+// NewJetStreamQueue connects to the NATS server at addr and ensures the
+// stream and durable pull consumer backing subject exist, failing fast with
+// a returned error (not a panic) if the server is unreachable.
+func NewJetStreamQueue(addr, subject, group string) (*JetStreamQueue, error) {
+	nc, err := nats.Connect(addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to init jetstream context: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     group,
+		Subjects: []string{subject, subject + ".dead"},
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to create jetstream stream: %w", err)
+	}
+
+	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:       jetStreamConsumer,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		MaxDeliver:    int(defaultMaxRetries) + 1, // JetStream counts the first attempt
+		FilterSubject: subject,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to create durable pull consumer: %w", err)
+	}
+
+	return &JetStreamQueue{
+		nc:          nc,
+		js:          js,
+		stream:      stream,
+		consumer:    consumer,
+		subject:     subject,
+		deadSubject: subject + ".dead",
+		group:       group,
+		maxRetries:  defaultMaxRetries,
+		pending:     make(map[string]jetstream.Msg),
+	}, nil
+}
+
+// SetMaxRetries records n as the default delivery-count ceiling. Nothing in
+// this driver reads it: redelivery is governed by MaxDeliver on the durable
+// consumer, which is fixed at creation time.
+func (q *JetStreamQueue) SetMaxRetries(n int64) {
+	q.maxRetries = n
+}
+
+// Publish publishes job to the stream's subject, honoring IdempotencyKey via
+// JetStream's Nats-Msg-Id de-duplication header, which the server itself
+// enforces over the stream's configured duplicate window. When the server
+// drops a message as a duplicate, Publish still returns job.ID, not the ID
+// of the job first published under that key.
+func (q *JetStreamQueue) Publish(ctx context.Context, job domain.Job) (string, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	msg := nats.NewMsg(q.subject)
+	msg.Data = data
+	if job.IdempotencyKey != "" {
+		msg.Header.Set(nats.MsgIdHdr, job.IdempotencyKey)
+	}
+
+	if _, err := q.js.PublishMsg(ctx, msg); err != nil {
+		return "", fmt.Errorf("jetstream publish failed: %w", err)
+	}
+	return job.ID, nil
+}
+
+// Subscribe pulls messages from the durable consumer and streams jobs to a
+// Go channel, stamping RawID with the message's stream sequence so
+// Acknowledge/DeadLetter can address it later.
+func (q *JetStreamQueue) Subscribe(ctx context.Context) (<-chan domain.Job, error) {
+	outCh := make(chan domain.Job)
+
+	consumeCtx, err := q.consumer.Consume(func(msg jetstream.Msg) {
+		var job domain.Job
+		if err := json.Unmarshal(msg.Data(), &job); err != nil {
+			slog.Error("Failed to unmarshal job", "error", err)
+			msg.Term() // malformed payload will never succeed; stop redelivering it
+			return
+		}
+
+		meta, err := msg.Metadata()
+		if err != nil {
+			slog.Error("Failed to read message metadata", "error", err)
+			return
+		}
+
+		job.RawID = fmt.Sprintf("%d", meta.Sequence.Stream)
+		job.DeliveryCount = int64(meta.NumDelivered)
+		job.Consumer = jetStreamConsumer
+
+		q.pendingMu.Lock()
+		q.pending[job.RawID] = msg
+		q.pendingMu.Unlock()
+
+		select {
+		case outCh <- job:
+		case <-ctx.Done():
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start consuming: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		consumeCtx.Stop()
+		close(outCh)
+	}()
+
+	return outCh, nil
+}
+
+// Acknowledge acks the message identified by rawID (its stream sequence),
+// using the jetstream.Msg handle Subscribe stashed for it — rawID alone
+// isn't enough to call msg.Ack(), which JetStream requires the original
+// handle for.
+func (q *JetStreamQueue) Acknowledge(ctx context.Context, rawID string) error {
+	msg, err := q.takePending(rawID)
+	if err != nil {
+		return err
+	}
+	return msg.Ack()
+}
+
+// takePending looks up and removes rawID's stashed jetstream.Msg handle,
+// shared by Acknowledge and DeadLetter so both ultimately ack the message
+// once the job is resolved.
+func (q *JetStreamQueue) takePending(rawID string) (jetstream.Msg, error) {
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+
+	msg, ok := q.pending[rawID]
+	if !ok {
+		return nil, fmt.Errorf("jetstream: no pending message handle for sequence %s (already acked, or delivered before a restart)", rawID)
+	}
+	delete(q.pending, rawID)
+	return msg, nil
+}
+
+// DeadLetter republishes job to the stream's dead-letter subject, annotated
+// with reason, then acknowledges the original message so JetStream stops
+// redelivering it.
+func (q *JetStreamQueue) DeadLetter(ctx context.Context, job domain.Job, reason string) error {
+	entry := deadJobEntry{
+		Job:              job,
+		Reason:           reason,
+		OriginalStreamID: job.RawID,
+		Consumer:         job.Consumer,
+		DeadLetteredAt:   time.Now(),
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead job: %w", err)
+	}
+
+	if _, err := q.js.Publish(ctx, q.deadSubject, data); err != nil {
+		return fmt.Errorf("failed to publish to dead-letter subject: %w", err)
+	}
+
+	if job.RawID == "" {
+		return nil
+	}
+	msg, err := q.takePending(job.RawID)
+	if err != nil {
+		return fmt.Errorf("failed to ack dead-lettered job: %w", err)
+	}
+	return msg.Ack()
+}
+
+// ListDead reads the first (oldest) limit entries from the dead-letter
+// subject via an ephemeral ordered consumer, and returns them most recent
+// first. Entries past the first limit are not read, and DeadJob.ID is left
+// empty.
+func (q *JetStreamQueue) ListDead(ctx context.Context, limit int64) ([]domain.DeadJob, error) {
+	cons, err := q.stream.OrderedConsumer(ctx, jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{q.deadSubject},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter reader: %w", err)
+	}
+
+	var entries []deadJobEntry
+	batch, err := cons.FetchNoWait(int(limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch dead-letter entries: %w", err)
+	}
+	for msg := range batch.Messages() {
+		var entry deadJobEntry
+		if err := json.Unmarshal(msg.Data(), &entry); err != nil {
+			slog.Error("Failed to unmarshal dead-letter entry", "error", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	dead := make([]domain.DeadJob, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		dead = append(dead, domain.DeadJob{
+			Job:              entry.Job,
+			Reason:           entry.Reason,
+			OriginalStreamID: entry.OriginalStreamID,
+			Consumer:         entry.Consumer,
+			DeadLetteredAt:   entry.DeadLetteredAt,
+		})
+	}
+	return dead, nil
+}
+
+// RequeueDead is not yet implemented for the JetStream driver: unlike Redis
+// Streams' XRANGE, there is no direct-by-ID lookup into an ordered consumer
+// without also tracking stream sequence numbers through ListDead.
+func (q *JetStreamQueue) RequeueDead(ctx context.Context, id string) error {
+	return fmt.Errorf("jetstream: requeueing a dead-lettered job by id is not yet supported")
+}
+
+// StoreResult and Result are not backed by JetStream itself (it has no
+// native key/value-with-TTL primitive as convenient as Redis's HSET+EXPIRE);
+// a production deployment would pair JetStream with a NATS KV bucket here.
+func (q *JetStreamQueue) StoreResult(ctx context.Context, result domain.JobResult) error {
+	return fmt.Errorf("jetstream: result caching requires a NATS KV bucket, not yet wired up")
+}
+
+func (q *JetStreamQueue) Result(ctx context.Context, jobID string) (domain.JobResult, bool, error) {
+	return domain.JobResult{}, false, fmt.Errorf("jetstream: result caching requires a NATS KV bucket, not yet wired up")
+}
+
+// Broadcast publishes result to the group's log subject for live tailers.
+func (q *JetStreamQueue) Broadcast(ctx context.Context, result domain.JobResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log: %w", err)
+	}
+	return q.nc.Publish(q.group+".logs", data)
+}
+
+// SubscribeLogs subscribes to the group's log subject and streams results to
+// a Go channel.
+func (q *JetStreamQueue) SubscribeLogs(ctx context.Context) (<-chan domain.JobResult, error) {
+	outCh := make(chan domain.JobResult)
+
+	sub, err := q.nc.Subscribe(q.group+".logs", func(msg *nats.Msg) {
+		var result domain.JobResult
+		if err := json.Unmarshal(msg.Data, &result); err != nil {
+			slog.Error("Failed to unmarshal log", "error", err)
+			return
+		}
+		select {
+		case outCh <- result:
+		case <-ctx.Done():
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to logs: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		sub.Unsubscribe()
+		close(outCh)
+	}()
+
+	return outCh, nil
+}
+
+// StartRecoveryRoutine is a no-op for JetStream: MaxDeliver on the durable
+// consumer already governs redelivery, and AckWait handles stale-claim
+// timeouts server-side, so there is no client-side PEL to sweep.
+func (q *JetStreamQueue) StartRecoveryRoutine(ctx context.Context, interval time.Duration, maxAge time.Duration) {
+	slog.Info("JetStream recovery is server-side (MaxDeliver/AckWait); recovery routine is a no-op", "interval", interval, "maxAge", maxAge)
+	<-ctx.Done()
+}
@@ -2,20 +2,30 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"time"
 
+	"github.com/dontdude/goxec/internal/domain"
 	"github.com/redis/go-redis/v9"
 )
 
-// StartRecoveryRoutine polls the PEL for stale jobs and reclaims them.
+// recoveryConsumer is the consumer name the recovery agent claims stale
+// messages under so XPENDING attributes them correctly.
+const recoveryConsumer = "recovery-agent"
+
+// StartRecoveryRoutine polls the PEL for stale jobs, reclaims them, and
+// dead-letters anything that has exceeded its retry budget instead of
+// claiming it forever. It sweeps both the shared stream and every
+// per-worker dedicated stream PublishRouted may have sent jobs to (see
+// knownWorkerStreams), so a job routed to a worker that later dies
+// permanently is still eventually reclaimed instead of sitting in that
+// worker's PEL forever.
 func (r *RedisQueue) StartRecoveryRoutine(ctx context.Context, interval time.Duration, maxAge time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	// Unique consumer ID for the recovery agent
-	consumerName := "recovery-agent"
-
 	slog.Info("Starting Redis Recovery Routine", "interval", interval, "maxAge", maxAge)
 
 	for {
@@ -23,50 +33,127 @@ func (r *RedisQueue) StartRecoveryRoutine(ctx context.Context, interval time.Dur
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			// XAUTOCLAIM: Finds messages pending for > maxAge
-			// and claims them to this consumer to be processed.
-			start := "-" // Start from beginning of stream
-			
-			for {
-				// We claim batches of 10
-				messages, nextStart, err := r.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
-					Stream:   r.stream,
-					Group:    r.group,
-					MinIdle:  maxAge,
-					Start:    start,
-					Count:    10,
-					Consumer: consumerName,
-				}).Result()
-
-				if err != nil {
-					slog.Error("Recovery routine failed", "error", err)
-					break
-				}
-				
-				if len(messages) == 0 {
-					break // No more stale messages
-				}
-
-				slog.Info("Recovered stale jobs", "count", len(messages))
-
-				// Process recovered messages.
-				// In a robust production system, you would:
-				// 1. Inspect the retry count (XDeliveryCount).
-				// 2. If retry count > MaxRetries, move to Dead Letter Queue (DLQ).
-				// 3. Else, re-enqueue or process immediately.
-				for _, msg := range messages {
-					slog.Warn("Stale job claimed by recovery agent", "msgID", msg.ID)
-					
-					// For this demonstration, we ACK the message to remove it from the PEL
-					// so it doesn't leak memory. In a real system, you might restart the job here.
-					r.client.XAck(ctx, r.stream, r.group, msg.ID)
-				}
-				
-				start = nextStart
-				if start == "0-0" {
-					break
-				}
+			r.reclaimStale(ctx, r.stream, maxAge)
+
+			streams, err := r.knownWorkerStreams(ctx)
+			if err != nil {
+				slog.Error("Failed to list worker streams for recovery sweep", "error", err)
+				continue
 			}
+			for _, stream := range streams {
+				r.reclaimStale(ctx, stream, maxAge)
+			}
+		}
+	}
+}
+
+// knownWorkerStreams returns the dedicated stream name for every worker ID
+// still in heartbeatKey (see Heartbeat), not just the currently-live ones
+// ActiveWorkers reports: a worker that crashed without deregistering leaves
+// its ID in heartbeatKey forever, and its dedicated stream can still hold
+// jobs PublishRouted sent it before it died. A worker whose StartHeartbeat
+// removed its ID on shutdown is not listed.
+func (r *RedisQueue) knownWorkerStreams(ctx context.Context) ([]string, error) {
+	members, err := r.client.ZRange(ctx, heartbeatKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list known workers: %w", err)
+	}
+
+	streams := make([]string, len(members))
+	for i, workerID := range members {
+		streams[i] = workerStream(workerID)
+	}
+	return streams, nil
+}
+
+// reclaimStale claims messages idle for longer than maxAge on stream and
+// either dead-letters them (delivery count exceeded) or hands them back to
+// the original consumer's retry path by leaving them claimed for
+// reprocessing.
+func (r *RedisQueue) reclaimStale(ctx context.Context, stream string, maxAge time.Duration) {
+	start := "-" // Start from beginning of stream
+
+	for {
+		// We claim batches of 10.
+		messages, nextStart, err := r.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    r.group,
+			MinIdle:  maxAge,
+			Start:    start,
+			Count:    10,
+			Consumer: recoveryConsumer,
+		}).Result()
+
+		if err != nil {
+			slog.Error("Recovery routine failed", "stream", stream, "error", err)
+			return
+		}
+
+		if len(messages) == 0 {
+			return // No more stale messages
 		}
+
+		slog.Info("Recovered stale jobs", "stream", stream, "count", len(messages))
+
+		for _, msg := range messages {
+			r.handleStaleMessage(ctx, stream, msg)
+		}
+
+		start = nextStart
+		if start == "0-0" {
+			return
+		}
+	}
+}
+
+// handleStaleMessage inspects a claimed message (from stream) delivery
+// count and either dead-letters it or leaves it claimed so a live worker
+// can retry it.
+func (r *RedisQueue) handleStaleMessage(ctx context.Context, stream string, msg redis.XMessage) {
+	pending, err := r.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  r.group,
+		Start:  msg.ID,
+		End:    msg.ID,
+		Count:  1,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		slog.Error("Failed to inspect delivery count", "stream", stream, "msgID", msg.ID, "error", err)
+		return
+	}
+	deliveryCount := pending[0].RetryCount
+
+	val, ok := msg.Values["job"].(string)
+	if !ok {
+		slog.Error("Invalid message format, acknowledging to drop it", "stream", stream, "msgID", msg.ID)
+		r.client.XAck(ctx, stream, r.group, msg.ID)
+		return
+	}
+
+	var job domain.Job
+	if err := json.Unmarshal([]byte(val), &job); err != nil {
+		slog.Error("Failed to unmarshal stale job, acknowledging to drop it", "stream", stream, "msgID", msg.ID, "error", err)
+		r.client.XAck(ctx, stream, r.group, msg.ID)
+		return
+	}
+	job.RawID = encodeRawID(stream, msg.ID)
+	job.DeliveryCount = deliveryCount
+	job.Consumer = recoveryConsumer
+
+	maxRetries := r.maxRetries
+	if job.MaxRetries > 0 {
+		maxRetries = job.MaxRetries
+	}
+
+	if deliveryCount <= maxRetries {
+		slog.Warn("Stale job reclaimed, left for retry", "stream", stream, "msgID", msg.ID, "deliveryCount", deliveryCount)
+		return
+	}
+
+	reason := fmt.Sprintf("exceeded max retries (%d/%d) while idle, last claimed by %s", deliveryCount, maxRetries, recoveryConsumer)
+	if err := r.DeadLetter(ctx, job, reason); err != nil {
+		slog.Error("Failed to dead-letter stale job", "stream", stream, "msgID", msg.ID, "error", err)
+		return
 	}
+	slog.Warn("Stale job dead-lettered after exceeding retries", "stream", stream, "msgID", msg.ID, "deliveryCount", deliveryCount)
 }
@@ -6,24 +6,53 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/dontdude/goxec/internal/domain"
 	"github.com/redis/go-redis/v9"
 )
 
+// defaultMaxRetries is how many times a job may be redelivered before the
+// recovery routine moves it to the dead-letter stream.
+const defaultMaxRetries = 5
+
+// idemKeyTTL bounds how long a submission's idempotency key de-duplicates
+// retries for, after which an identical key is treated as a new submission.
+const idemKeyTTL = 24 * time.Hour
+
+// resultTTL bounds how long a finished job's result stays cached for
+// Result to serve repeat submissions and post-crash reconnects.
+const resultTTL = 24 * time.Hour
+
+// defaultClaimIdleTimeout is how long a pending entry must have gone
+// unacknowledged before Subscribe's startup PEL drain will claim it for the
+// new consumer, on the assumption the worker that was delivered it crashed.
+const defaultClaimIdleTimeout = 30 * time.Second
+
 // RedisQueue implements domain.JobQueue using Redis Streams.
 type RedisQueue struct {
 	client *redis.Client
 	stream string
-	group string
+	group  string
+
+	// deadStream holds jobs that exceeded maxRetries, annotated with failure context.
+	deadStream string
+	// maxRetries is the default delivery-count ceiling; domain.Job.MaxRetries overrides it per job.
+	maxRetries int64
+	// claimIdleTimeout is how long a pending entry must be idle before
+	// Subscribe's startup PEL drain claims it for the new consumer.
+	claimIdleTimeout time.Duration
 }
 
 // Ensure RedisQueue satisfies the interface
 var _ domain.JobQueue = (*RedisQueue)(nil)
 
-// NewRedisQueue returns a new Redis-backed queue adapter.
-func NewRedisQueue(addr, stream, group string) (*RedisQueue) {
+// NewRedisQueue returns a new Redis-backed queue adapter. It fails fast with
+// a returned error (rather than panicking) if Redis is unreachable, so
+// callers such as queue.New and tests can compose it with other drivers and
+// handle startup failure themselves.
+func NewRedisQueue(addr, stream, group string) (*RedisQueue, error) {
 	rdb := redis.NewClient(&redis.Options{
 		Addr: addr,
 	})
@@ -32,49 +61,161 @@ func NewRedisQueue(addr, stream, group string) (*RedisQueue) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := rdb.Ping(ctx).Err(); err != nil {
-		panic(fmt.Sprintf("failed to connect to redis: %v", err))
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
 	}
 
 	return &RedisQueue{
-		client: rdb,
-		stream: stream,
-		group: group,
-	}
+		client:           rdb,
+		stream:           stream,
+		group:            group,
+		deadStream:       stream + ":dead",
+		maxRetries:       defaultMaxRetries,
+		claimIdleTimeout: defaultClaimIdleTimeout,
+	}, nil
+}
+
+// SetMaxRetries overrides the default delivery-count ceiling used by the
+// recovery routine when no per-job domain.Job.MaxRetries is set.
+func (r *RedisQueue) SetMaxRetries(n int64) {
+	r.maxRetries = n
+}
+
+// SetClaimIdleTimeout overrides how long a pending entry must be idle before
+// Subscribe's startup PEL drain will claim it for the new consumer.
+func (r *RedisQueue) SetClaimIdleTimeout(d time.Duration) {
+	r.claimIdleTimeout = d
 }
 
-// Publish enqueues a job to the Redis stream using XADD (Producer)
-func (r *RedisQueue) Publish(ctx context.Context, job domain.Job) error {
+// idemKey returns the Redis key holding the job ID a given idempotency key
+// was last published under.
+func idemKey(key string) string {
+	return "goxec:idem:" + key
+}
+
+// Publish enqueues a job to the Redis stream using XADD (Producer). If
+// job.IdempotencyKey is set and still live from an earlier submission,
+// Publish skips enqueueing and returns that earlier job's ID instead, so
+// clients retrying on network failure don't trigger duplicate executions.
+func (r *RedisQueue) Publish(ctx context.Context, job domain.Job) (string, error) {
+	return r.publishTo(ctx, r.stream, job)
+}
+
+// publishTo is the shared implementation behind Publish and PublishRouted:
+// it honors job.IdempotencyKey, then XADDs to the given stream rather than
+// always the shared one.
+func (r *RedisQueue) publishTo(ctx context.Context, stream string, job domain.Job) (string, error) {
+	claimedIdemKey := false
+	if job.IdempotencyKey != "" {
+		ok, err := r.client.SetNX(ctx, idemKey(job.IdempotencyKey), job.ID, idemKeyTTL).Result()
+		if err != nil {
+			return "", fmt.Errorf("failed to check idempotency key: %w", err)
+		}
+		if !ok {
+			existingID, err := r.client.Get(ctx, idemKey(job.IdempotencyKey)).Result()
+			if err != nil {
+				return "", fmt.Errorf("failed to read idempotency key: %w", err)
+			}
+			return existingID, nil
+		}
+		claimedIdemKey = true
+	}
+
 	data, err := json.Marshal(job)
 	if err != nil {
-		return fmt.Errorf("failed to marshal job: %w", err)
+		r.releaseIdemKeyOnFailure(job, claimedIdemKey)
+		return "", fmt.Errorf("failed to marshal job: %w", err)
 	}
 
 	// XADD appends to the stream.
 	// We use "*" Id to let Redis generate a timestamp-based ID.
 	err = r.client.XAdd(ctx, &redis.XAddArgs{
-		Stream: r.stream,
+		Stream: stream,
 		Values: map[string]interface{}{
 			"job": data,
 		},
 	}).Err()
 
 	if err != nil {
-		return fmt.Errorf("redis publish failed: %w", err)
+		r.releaseIdemKeyOnFailure(job, claimedIdemKey)
+		return "", fmt.Errorf("redis publish failed: %w", err)
 	}
-	return nil
+	return job.ID, nil
 }
 
-// Subscribe returns a channel of jobs using the XREADGROUP (Consumer).
+// releaseIdemKeyOnFailure deletes job's idempotency key after SetNX claimed
+// it but the publish it was guarding never actually happened, so it doesn't
+// poison every retry for the rest of idemKeyTTL with a job ID that was never
+// enqueued: Result would report "not found" forever, indistinguishable from
+// "still running", instead of the retry re-publishing as intended. Best
+// effort: a failure here only means the key lingers until its TTL expires,
+// not that the caller's publish error should be masked.
+func (r *RedisQueue) releaseIdemKeyOnFailure(job domain.Job, claimedIdemKey bool) {
+	if !claimedIdemKey {
+		return
+	}
+	if err := r.client.Del(context.Background(), idemKey(job.IdempotencyKey)).Err(); err != nil {
+		slog.Error("Failed to release idempotency key after publish failure", "idempotencyKey", job.IdempotencyKey, "error", err)
+	}
+}
+
+// rawIDSep separates a job's origin stream from its Redis Stream entry ID
+// inside Job.RawID, so Acknowledge/DeadLetter know which stream to XACK
+// against even when the job was routed to a worker-specific stream (see
+// PublishRouted) rather than the shared one.
+const rawIDSep = "|"
+
+// encodeRawID packs stream and msgID into the Job.RawID JobQueue.Acknowledge
+// and JobQueue.DeadLetter are later called with.
+func encodeRawID(stream, msgID string) string {
+	return stream + rawIDSep + msgID
+}
+
+// decodeRawID splits a Job.RawID back into its origin stream and entry ID.
+// A RawID with no separator predates per-worker routing and is assumed to
+// belong to the shared stream.
+func (r *RedisQueue) decodeRawID(rawID string) (stream, msgID string) {
+	if stream, msgID, ok := strings.Cut(rawID, rawIDSep); ok {
+		return stream, msgID
+	}
+	return r.stream, rawID
+}
+
+// decodeJob unmarshals a stream message's "job" field.
+func decodeJob(msg redis.XMessage) (domain.Job, bool) {
+	val, ok := msg.Values["job"].(string)
+	if !ok {
+		slog.Error("Invalid message format", "msgID", msg.ID)
+		return domain.Job{}, false
+	}
+
+	var job domain.Job
+	if err := json.Unmarshal([]byte(val), &job); err != nil {
+		slog.Error("Failed to unmarshal job", "error", err)
+		return domain.Job{}, false
+	}
+	return job, true
+}
+
+// Subscribe returns a channel of jobs using the XREADGROUP (Consumer),
+// reading from the shared stream every worker competes for.
 func (r *RedisQueue) Subscribe(ctx context.Context) (<-chan domain.Job, error) {
+	return r.subscribeStream(ctx, r.stream)
+}
+
+// subscribeStream is the shared implementation behind Subscribe and
+// SubscribeWorker: it reads jobs off stream via a consumer in r.group, using
+// XREADGROUP (new messages) and a startup drainPEL pass (stale pending
+// entries from a crashed consumer).
+func (r *RedisQueue) subscribeStream(ctx context.Context, stream string) (<-chan domain.Job, error) {
 	// 1. Ensure the Consumer Group exists
 	// MkStream guarantees the stream exists even if empty.
-	err := r.client.XGroupCreateMkStream(ctx, r.stream, r.group, "$").Err()
+	err := r.client.XGroupCreateMkStream(ctx, stream, r.group, "$").Err()
 	if err != nil {
 		// Ignore "BUSYGROUP Consumer Group name already exists" error
 		if err.Error() != "BUSYGROUP Consumer Group name already exists" {
 			return nil, fmt.Errorf("failed to create consumer group: %w", err)
 		}
-	} 
+	}
 
 	// 2. Spawn a background listener
 	outCh := make(chan domain.Job)
@@ -88,16 +229,21 @@ func (r *RedisQueue) Subscribe(ctx context.Context) (<-chan domain.Job, error) {
 	go func() {
 		defer close(outCh)
 
+		// Before reading anything new, claim any pending entries left behind
+		// by a crashed worker so they're redelivered right away rather than
+		// waiting for the next periodic recovery tick.
+		r.drainPEL(ctx, stream, consumerId, outCh)
+
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			default: 
+			default:
 				// XREADGROUP blocks until a message is available (Block: 0 means forever, but we use 2s to check context)
 				streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
 					Group:    r.group,
 					Consumer: consumerId,
-					Streams:  []string{r.stream, ">"}, // ">" means new messages
+					Streams:  []string{stream, ">"}, // ">" means new messages
 					Count:    1,
 					Block:    2 * time.Second,
 				}).Result()
@@ -114,23 +260,18 @@ func (r *RedisQueue) Subscribe(ctx context.Context) (<-chan domain.Job, error) {
 					continue
 				}
 				// Process Messages
-				for _, stream := range streams {
-					for _, msg := range stream.Messages {
-						// Extract Job Data
-						val, ok := msg.Values["job"].(string)
+				for _, s := range streams {
+					for _, msg := range s.Messages {
+						job, ok := decodeJob(msg)
 						if !ok {
-							slog.Error("Invalid message format", "msgID", msg.ID)
 							continue
 						}
-						var job domain.Job
-						if err := json.Unmarshal([]byte(val), &job); err != nil {
-							slog.Error("Failed to unmarshal job", "error", err)
-							continue
-						}
-						
-						// Capture the Redis Stream ID so we can ACK later
-						job.RawID = msg.ID
-						
+
+						// Capture the Redis Stream ID (plus its origin stream)
+						// so we can ACK later.
+						job.RawID = encodeRawID(stream, msg.ID)
+						job.Consumer = consumerId
+
 						outCh <- job
 					}
 				}
@@ -140,9 +281,237 @@ func (r *RedisQueue) Subscribe(ctx context.Context) (<-chan domain.Job, error) {
 	return outCh, nil
 }
 
-// Acknowledge confirms processing using XACK. 
+// drainPEL claims any pending entries on stream that have sat unacknowledged
+// for at least claimIdleTimeout, on the assumption the consumer they were
+// delivered to crashed before processing them. It runs once at the start of
+// subscribeStream so a worker that (re)joins the group redelivers those jobs
+// immediately instead of waiting for the next periodic recovery tick
+// (recovery.go's reclaimStale). Entries
+// that already exceed their retry budget are dead-lettered instead of
+// redelivered.
+func (r *RedisQueue) drainPEL(ctx context.Context, stream, consumerId string, outCh chan<- domain.Job) {
+	pending, err := r.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  r.group,
+		Idle:   r.claimIdleTimeout,
+		Start:  "-",
+		End:    "+",
+		Count:  100,
+	}).Result()
+	if err != nil {
+		slog.Error("Failed to query pending entries", "error", err)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	ids := make([]string, len(pending))
+	retries := make(map[string]int64, len(pending))
+	for i, p := range pending {
+		ids[i] = p.ID
+		retries[p.ID] = p.RetryCount
+	}
+
+	msgs, err := r.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   stream,
+		Group:    r.group,
+		Consumer: consumerId,
+		MinIdle:  r.claimIdleTimeout,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		slog.Error("Failed to claim pending entries", "error", err)
+		return
+	}
+
+	for _, msg := range msgs {
+		job, ok := decodeJob(msg)
+		if !ok {
+			continue
+		}
+
+		job.RawID = encodeRawID(stream, msg.ID)
+		job.Consumer = consumerId
+		job.DeliveryCount = retries[msg.ID]
+
+		maxRetries := r.maxRetries
+		if job.MaxRetries > 0 {
+			maxRetries = job.MaxRetries
+		}
+		if job.DeliveryCount > maxRetries {
+			if err := r.DeadLetter(ctx, job, "exceeded max retries while claiming stale pending entry"); err != nil {
+				slog.Error("Failed to dead-letter stale job", "jobID", job.ID, "error", err)
+			}
+			continue
+		}
+
+		select {
+		case outCh <- job:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Acknowledge confirms processing using XACK, against whichever stream the
+// job was actually delivered from (see decodeRawID).
 func (r *RedisQueue) Acknowledge(ctx context.Context, jobID string) error {
-	return r.client.XAck(ctx, r.stream, r.group, jobID).Err()
+	stream, msgID := r.decodeRawID(jobID)
+	return r.client.XAck(ctx, stream, r.group, msgID).Err()
+}
+
+// deadJobEntry is the JSON payload stored in the dead-letter stream.
+// It wraps the original job with the failure context an operator needs to triage it.
+type deadJobEntry struct {
+	Job              domain.Job `json:"job"`
+	Reason           string     `json:"reason"`
+	OriginalStreamID string     `json:"original_stream_id"`
+	Consumer         string     `json:"consumer"`
+	DeadLetteredAt   time.Time  `json:"dead_lettered_at"`
+}
+
+// DeadLetter moves job from its origin stream's PEL into the shared
+// dead-letter stream, then ACKs the original entry so it stops showing up as
+// pending.
+func (r *RedisQueue) DeadLetter(ctx context.Context, job domain.Job, reason string) error {
+	entry := deadJobEntry{
+		Job:              job,
+		Reason:           reason,
+		OriginalStreamID: job.RawID,
+		Consumer:         job.Consumer,
+		DeadLetteredAt:   time.Now(),
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead job: %w", err)
+	}
+
+	if err := r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.deadStream,
+		Values: map[string]interface{}{"entry": data},
+	}).Err(); err != nil {
+		return fmt.Errorf("failed to append to dead-letter stream: %w", err)
+	}
+
+	if job.RawID != "" {
+		stream, msgID := r.decodeRawID(job.RawID)
+		if err := r.client.XAck(ctx, stream, r.group, msgID).Err(); err != nil {
+			return fmt.Errorf("failed to ack dead-lettered job: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ListDead returns up to limit dead-lettered jobs, most recent first.
+func (r *RedisQueue) ListDead(ctx context.Context, limit int64) ([]domain.DeadJob, error) {
+	msgs, err := r.client.XRevRangeN(ctx, r.deadStream, "+", "-", limit).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter stream: %w", err)
+	}
+
+	dead := make([]domain.DeadJob, 0, len(msgs))
+	for _, msg := range msgs {
+		val, ok := msg.Values["entry"].(string)
+		if !ok {
+			slog.Error("Invalid dead-letter entry format", "msgID", msg.ID)
+			continue
+		}
+
+		var entry deadJobEntry
+		if err := json.Unmarshal([]byte(val), &entry); err != nil {
+			slog.Error("Failed to unmarshal dead-letter entry", "error", err)
+			continue
+		}
+
+		dead = append(dead, domain.DeadJob{
+			ID:               msg.ID,
+			Job:              entry.Job,
+			Reason:           entry.Reason,
+			OriginalStreamID: entry.OriginalStreamID,
+			Consumer:         entry.Consumer,
+			DeadLetteredAt:   entry.DeadLetteredAt,
+		})
+	}
+
+	return dead, nil
+}
+
+// RequeueDead re-publishes a dead-lettered job back onto the live stream
+// and removes its entry from the dead-letter stream.
+func (r *RedisQueue) RequeueDead(ctx context.Context, id string) error {
+	msgs, err := r.client.XRange(ctx, r.deadStream, id, id).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read dead-letter entry: %w", err)
+	}
+	if len(msgs) == 0 {
+		return fmt.Errorf("dead-letter entry %s not found", id)
+	}
+
+	val, ok := msgs[0].Values["entry"].(string)
+	if !ok {
+		return fmt.Errorf("dead-letter entry %s is malformed", id)
+	}
+
+	var entry deadJobEntry
+	if err := json.Unmarshal([]byte(val), &entry); err != nil {
+		return fmt.Errorf("failed to unmarshal dead-letter entry: %w", err)
+	}
+
+	// Reset retry bookkeeping so the requeued job gets a fresh delivery budget.
+	entry.Job.RawID = ""
+	entry.Job.DeliveryCount = 0
+	entry.Job.LastError = ""
+	// Requeueing is an explicit operator action, not a client retry, so it
+	// should never be deduplicated against the original submission's key.
+	entry.Job.IdempotencyKey = ""
+
+	if _, err := r.Publish(ctx, entry.Job); err != nil {
+		return fmt.Errorf("failed to republish dead-lettered job: %w", err)
+	}
+
+	return r.client.XDel(ctx, r.deadStream, id).Err()
+}
+
+// resultKey returns the Redis key a job's cached JobResult is stored under.
+func resultKey(jobID string) string {
+	return "goxec:result:" + jobID
+}
+
+// StoreResult caches a job's finished JobResult in a Redis hash so Result
+// can serve repeat submissions and post-crash reconnects without re-running
+// the job. The entry expires after resultTTL.
+func (r *RedisQueue) StoreResult(ctx context.Context, result domain.JobResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %w", err)
+	}
+
+	key := resultKey(result.JobID)
+	if err := r.client.HSet(ctx, key, "result", data).Err(); err != nil {
+		return fmt.Errorf("failed to store result: %w", err)
+	}
+	return r.client.Expire(ctx, key, resultTTL).Err()
+}
+
+// Result looks up a previously cached JobResult for jobID. found is false if
+// no result has been stored yet (job still running, or cache expired).
+func (r *RedisQueue) Result(ctx context.Context, jobID string) (domain.JobResult, bool, error) {
+	data, err := r.client.HGet(ctx, resultKey(jobID), "result").Result()
+	if err == redis.Nil {
+		return domain.JobResult{}, false, nil
+	}
+	if err != nil {
+		return domain.JobResult{}, false, fmt.Errorf("failed to read result: %w", err)
+	}
+
+	var result domain.JobResult
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		return domain.JobResult{}, false, fmt.Errorf("failed to unmarshal result: %w", err)
+	}
+	return result, true, nil
 }
 
 // Broadcast publishes the execution result to the "goxec:logs" channel.
@@ -195,5 +564,67 @@ func (r *RedisQueue) SubscribeLogs(ctx context.Context) (<-chan domain.JobResult
 		}
 	}()
 
+	return outCh, nil
+}
+
+// streamChannel returns the Redis pub/sub channel name a job's live output is
+// published on.
+func streamChannel(jobID string) string {
+	return "goxec:stream:" + jobID
+}
+
+// PublishStream publishes one incremental StreamEvent for jobID, so
+// subscribers see output as the container produces it.
+func (r *RedisQueue) PublishStream(ctx context.Context, jobID string, event domain.StreamEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stream event: %w", err)
+	}
+
+	return r.client.Publish(ctx, streamChannel(jobID), data).Err()
+}
+
+// SubscribeStream subscribes to jobID's live output channel and streams
+// StreamEvents to a Go channel until ctx is cancelled or the job's "exit"
+// event is observed.
+func (r *RedisQueue) SubscribeStream(ctx context.Context, jobID string) (<-chan domain.StreamEvent, error) {
+	pubsub := r.client.Subscribe(ctx, streamChannel(jobID))
+
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return nil, fmt.Errorf("failed to subscribe to job stream: %w", err)
+	}
+
+	outCh := make(chan domain.StreamEvent)
+
+	go func() {
+		defer close(outCh)
+		defer pubsub.Close()
+
+		ch := pubsub.Channel()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+
+				var event domain.StreamEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					slog.Error("Failed to unmarshal stream event", "error", err)
+					continue
+				}
+
+				outCh <- event
+
+				if event.Kind == "exit" {
+					return
+				}
+			}
+		}
+	}()
+
 	return outCh, nil
 } 
\ No newline at end of file
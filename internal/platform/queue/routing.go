@@ -0,0 +1,116 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dontdude/goxec/internal/domain"
+	"github.com/dontdude/goxec/internal/routing"
+	"github.com/redis/go-redis/v9"
+)
+
+// heartbeatKey holds a sorted set of worker IDs, scored by the Unix time of
+// their last heartbeat. Hash-tagged on its own so it always lives on one
+// Redis Cluster slot regardless of the job stream's tag.
+const heartbeatKey = "{goxec:workers}:heartbeat"
+
+// defaultWorkerTTL is how stale a worker's heartbeat may be before
+// ActiveWorkers stops considering it live.
+const defaultWorkerTTL = 45 * time.Second
+
+// workerStream returns the dedicated stream jobs routed to workerID are
+// published onto.
+func workerStream(workerID string) string {
+	return fmt.Sprintf("{goxec:jobs}:worker:%s", workerID)
+}
+
+// Heartbeat records workerID as live as of now. Call it periodically (see
+// StartHeartbeat) for as long as the worker is willing to accept routed jobs.
+func (r *RedisQueue) Heartbeat(ctx context.Context, workerID string) error {
+	return r.client.ZAdd(ctx, heartbeatKey, redis.Z{
+		Score:  float64(time.Now().Unix()),
+		Member: workerID,
+	}).Err()
+}
+
+// StartHeartbeat refreshes workerID's heartbeat every interval until ctx is
+// cancelled, at which point it removes the membership immediately rather
+// than waiting for it to age out, so PublishRouted stops routing new jobs to
+// a worker that's already shutting down.
+func (r *RedisQueue) StartHeartbeat(ctx context.Context, workerID string, interval time.Duration) {
+	if err := r.Heartbeat(ctx, workerID); err != nil {
+		slog.Error("Failed to register worker heartbeat", "workerID", workerID, "error", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.client.ZRem(context.Background(), heartbeatKey, workerID).Err(); err != nil {
+				slog.Error("Failed to deregister worker heartbeat", "workerID", workerID, "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := r.Heartbeat(ctx, workerID); err != nil {
+				slog.Error("Failed to refresh worker heartbeat", "workerID", workerID, "error", err)
+			}
+		}
+	}
+}
+
+// ActiveWorkers returns the IDs of workers that have heartbeat within ttl,
+// sorted lexicographically so repeated calls agree on ordering (ZRANGEBYSCORE
+// orders by score, which drifts between calls since the score is a
+// timestamp) -- Jump Hash's bucket assignment depends on that order staying
+// stable as long as membership doesn't change.
+func (r *RedisQueue) ActiveWorkers(ctx context.Context, ttl time.Duration) ([]string, error) {
+	cutoff := time.Now().Add(-ttl).Unix()
+	members, err := r.client.ZRangeByScore(ctx, heartbeatKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(cutoff, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active workers: %w", err)
+	}
+
+	sort.Strings(members)
+	return members, nil
+}
+
+// PublishRouted publishes job to a specific worker's dedicated stream,
+// chosen by Jump Consistent Hash over job.ID, so the same job ID is (almost)
+// always routed to the same worker -- useful for keeping a warmed per-worker
+// language runtime/image cache effective. Jobs marked Stateless, and jobs
+// published while no worker has a live heartbeat, fall back to Publish's
+// shared stream, which any worker's Subscribe can pick up.
+func (r *RedisQueue) PublishRouted(ctx context.Context, job domain.Job) (string, error) {
+	if job.Stateless {
+		return r.Publish(ctx, job)
+	}
+
+	workers, err := r.ActiveWorkers(ctx, defaultWorkerTTL)
+	if err != nil {
+		return "", err
+	}
+	if len(workers) == 0 {
+		return r.Publish(ctx, job)
+	}
+
+	bucket := routing.Hash(routing.KeyFor(job.ID), len(workers))
+	return r.publishTo(ctx, workerStream(workers[bucket]), job)
+}
+
+// SubscribeWorker is Subscribe's counterpart for a specific worker identity:
+// it reads only jobs PublishRouted sent to workerID's dedicated stream.
+// Workers that want affinity routing should call both SubscribeWorker (for
+// routed jobs) and Subscribe (for Stateless jobs and the no-workers-live
+// fallback), merging both channels.
+func (r *RedisQueue) SubscribeWorker(ctx context.Context, workerID string) (<-chan domain.Job, error) {
+	return r.subscribeStream(ctx, workerStream(workerID))
+}
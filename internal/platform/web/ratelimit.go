@@ -2,10 +2,15 @@ package web
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/dontdude/goxec/internal/metrics"
 )
 
 // Default cleanup intervals.
@@ -14,11 +19,78 @@ const (
 	visitorTimeout  = 3 * time.Minute
 )
 
+// Limiter is satisfied by both RateLimiter (per-process) and
+// RedisRateLimiter (distributed), so callers can select an implementation
+// via config without caring which one is actually live.
+type Limiter interface {
+	Allow(ip string) bool
+	RateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc
+}
+
+var (
+	_ Limiter = (*RateLimiter)(nil)
+	_ Limiter = (*RedisRateLimiter)(nil)
+)
+
+// NewLimiterFromEnv selects a rate limiter implementation via
+// GOXEC_RATELIMIT_DRIVER ("memory", the default, or "redis"), so a single
+// replica can run in-process while a fleet behind a load balancer shares
+// capacity through Redis. GOXEC_RATELIMIT_ADDR overrides the Redis address
+// when the driver is "redis".
+func NewLimiterFromEnv(rate, capacity float64, defaultRedisAddr string) Limiter {
+	if os.Getenv("GOXEC_RATELIMIT_DRIVER") == "redis" {
+		addr := os.Getenv("GOXEC_RATELIMIT_ADDR")
+		if addr == "" {
+			addr = defaultRedisAddr
+		}
+		return NewRedisRateLimiter(addr, rate, capacity)
+	}
+	return NewRateLimiter(rate, capacity)
+}
+
+// clientIP extracts the request's client address. A reverse proxy's
+// X-Forwarded-For may chain multiple hops ("client, proxy1, proxy2"), with
+// each proxy appending the address it saw to the right end of the header. We
+// only ever sit behind one trusted proxy (ours), so the right-most entry is
+// the one it appended, not the client-suppliable entries to its left: a
+// client can put anything it wants as the left-most hop, so trusting that
+// one lets any request forge a fresh bucket on every call. Without the
+// header at all, RemoteAddr's host is used, via net.SplitHostPort rather
+// than a naive colon-split so IPv6 addresses ("[::1]:54321") aren't mangled.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		if idx := strings.LastIndexByte(fwd, ','); idx != -1 {
+			return strings.TrimSpace(fwd[idx+1:])
+		}
+		return strings.TrimSpace(fwd)
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writeTooManyRequests writes the shared 429 response body, including a
+// Retry-After header derived from the bucket's refill rate so well-behaved
+// clients know how long to back off instead of hammering immediately again.
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(retryAfter.Round(time.Second).Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Too Many Requests"})
+}
+
 // Client represents a single visitor (IP) and their token bucket state.
 type Client struct {
 	// mu protects the individual client's state (tokens, lastRefill).
 	// This allows concurrent updates to different clients without contention.
-	mu 		   sync.Mutex
+	mu         sync.Mutex
 	tokens     float64
 	lastRefill time.Time
 }
@@ -41,7 +113,7 @@ type RateLimiter struct {
 func NewRateLimiter(rate, capacity float64) *RateLimiter {
 	rl := &RateLimiter{
 		clients:  make(map[string]*Client),
-		rate:	  rate,
+		rate:     rate,
 		capacity: capacity,
 	}
 
@@ -81,6 +153,14 @@ func (rl *RateLimiter) getClient(ip string) *Client {
 // Allow checks if the request is allowed for the give IP.
 // Implements the "Lazy Refill" algorithm.
 func (rl *RateLimiter) Allow(ip string) bool {
+	allowed, _ := rl.checkWithRetry(ip)
+	return allowed
+}
+
+// checkWithRetry is Allow's implementation, additionally returning how long
+// the caller should wait before retrying when the request isn't allowed, so
+// the middleware can set a Retry-After header without recomputing state.
+func (rl *RateLimiter) checkWithRetry(ip string) (bool, time.Duration) {
 	c := rl.getClient(ip)
 
 	// Lock only this specific client
@@ -104,13 +184,14 @@ func (rl *RateLimiter) Allow(ip string) bool {
 	// 2. Consume token
 	if c.tokens >= 1.0 {
 		c.tokens--
-		return true
+		return true, 0
 	}
 
-	return false
+	needed := 1.0 - c.tokens
+	return false, time.Duration(needed / rl.rate * float64(time.Second))
 }
 
-// cleanupVisitors removes inactive clients to prevent memory leaks. 
+// cleanupVisitors removes inactive clients to prevent memory leaks.
 func (rl *RateLimiter) cleanupVisitors() {
 	for {
 		time.Sleep(cleanupInterval)
@@ -130,21 +211,15 @@ func (rl *RateLimiter) cleanupVisitors() {
 // RateLimitMiddleware wraps an http.Handler to enforce rate limits.
 func (rl *RateLimiter) RateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Extract IP (Basic implementation)
-		ip := r.RemoteAddr
-		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
-			ip = fwd
-		} else if strings.Contains(ip, ":") {
-			ip = strings.Split(ip, ":")[0]
-		}
+		ip := clientIP(r)
 
-		if !rl.Allow(ip) {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusTooManyRequests)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Too Many Requests"})
+		if allowed, retryAfter := rl.checkWithRetry(ip); !allowed {
+			metrics.RateLimitDecisions.Inc(r.URL.Path, "deny")
+			writeTooManyRequests(w, retryAfter)
 			return
 		}
 
+		metrics.RateLimitDecisions.Inc(r.URL.Path, "allow")
 		next(w, r)
 	}
-}
\ No newline at end of file
+}
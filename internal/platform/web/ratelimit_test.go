@@ -0,0 +1,76 @@
+package web
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllowsUpToCapacityThenBlocks(t *testing.T) {
+	rl := NewRateLimiter(1, 3)
+
+	for i := 0; i < 3; i++ {
+		if !rl.Allow("1.2.3.4") {
+			t.Fatalf("Allow() on request %d = false, want true (within capacity)", i)
+		}
+	}
+
+	if rl.Allow("1.2.3.4") {
+		t.Fatal("Allow() after exhausting capacity = true, want false")
+	}
+}
+
+func TestRateLimiterTracksClientsIndependently(t *testing.T) {
+	rl := NewRateLimiter(1, 1)
+
+	if !rl.Allow("1.1.1.1") {
+		t.Fatal("Allow(1.1.1.1) = false, want true")
+	}
+	if !rl.Allow("2.2.2.2") {
+		t.Fatal("Allow(2.2.2.2) = false, want true (separate bucket from 1.1.1.1)")
+	}
+	if rl.Allow("1.1.1.1") {
+		t.Fatal("second Allow(1.1.1.1) = true, want false (bucket exhausted)")
+	}
+}
+
+func TestRateLimiterRefillsOverTime(t *testing.T) {
+	rl := NewRateLimiter(1000, 1)
+
+	if !rl.Allow("1.2.3.4") {
+		t.Fatal("Allow() = false, want true")
+	}
+	if rl.Allow("1.2.3.4") {
+		t.Fatal("Allow() immediately after exhausting = true, want false")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if !rl.Allow("1.2.3.4") {
+		t.Fatal("Allow() after refill window = false, want true")
+	}
+}
+
+func TestClientIPPrefersRightmostForwardedForHop(t *testing.T) {
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	r.RemoteAddr = "10.0.0.1:5555"
+	r.Header.Set("X-Forwarded-For", "9.9.9.9, 10.0.0.2")
+
+	if got := clientIP(r); got != "10.0.0.2" {
+		t.Fatalf("clientIP() = %q, want %q (right-most, trusted hop)", got, "10.0.0.2")
+	}
+}
+
+func TestClientIPFallsBackToRemoteAddr(t *testing.T) {
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	r.RemoteAddr = "[::1]:54321"
+
+	if got := clientIP(r); got != "::1" {
+		t.Fatalf("clientIP() = %q, want %q", got, "::1")
+	}
+}
@@ -0,0 +1,150 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dontdude/goxec/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// ratelimitKeyPrefix namespaces bucket keys in Redis's shared keyspace.
+const ratelimitKeyPrefix = "goxec:ratelimit:"
+
+// ratelimitTTL bounds how long an idle bucket lingers in Redis, mirroring
+// RateLimiter's cleanupVisitors for the in-process implementation.
+const ratelimitTTL = 3 * time.Minute
+
+// tokenBucketScript atomically reads a client's {tokens, last_refill} hash,
+// applies a lazy refill using Redis's own clock via TIME (so clients with
+// skewed clocks, or clocks on different replicas, can't affect the result),
+// consumes a token if one is available, and writes the outcome back. Running
+// this server-side means concurrent requests for the same IP across
+// different replicas can't race between reading and writing the bucket.
+const tokenBucketScript = `
+local tokens_key = "tokens"
+local refill_key = "last_refill"
+
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local bucket = redis.call("HMGET", KEYS[1], tokens_key, refill_key)
+local tokens = tonumber(bucket[1])
+local lastRefill = tonumber(bucket[2])
+if tokens == nil then
+	tokens = capacity
+	lastRefill = now
+end
+
+local elapsed = now - lastRefill
+if elapsed > 0 then
+	tokens = math.min(capacity, tokens + elapsed * rate)
+	lastRefill = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], tokens_key, tokens, refill_key, lastRefill)
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+
+local needed = 1 - tokens
+if needed < 0 then
+	needed = 0
+end
+
+return {allowed, tostring(needed / rate)}
+`
+
+// RedisRateLimiter is a distributed token-bucket rate limiter: every replica
+// behind a load balancer shares the same bucket per IP through Redis,
+// instead of each replica independently granting its own capacity like
+// RateLimiter does. It satisfies the same Allow/RateLimitMiddleware contract
+// so callers can select between them via config.
+type RedisRateLimiter struct {
+	client   *redis.Client
+	rate     float64
+	capacity float64
+}
+
+// NewRedisRateLimiter connects to the Redis instance at addr. rate and
+// capacity have the same meaning as RateLimiter's.
+func NewRedisRateLimiter(addr string, rate, capacity float64) *RedisRateLimiter {
+	return &RedisRateLimiter{
+		client:   redis.NewClient(&redis.Options{Addr: addr}),
+		rate:     rate,
+		capacity: capacity,
+	}
+}
+
+func bucketKey(ip string) string {
+	return ratelimitKeyPrefix + ip
+}
+
+// check runs tokenBucketScript for ip, returning whether the request is
+// allowed and, if not, how long the caller should wait before retrying.
+func (rl *RedisRateLimiter) check(ctx context.Context, ip string) (bool, time.Duration, error) {
+	res, err := rl.client.Eval(ctx, tokenBucketScript, []string{bucketKey(ip)}, rl.rate, rl.capacity, int(ratelimitTTL.Seconds())).Result()
+	if err != nil {
+		return false, 0, fmt.Errorf("rate limit script: %w", err)
+	}
+
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("rate limit script: unexpected result %v", res)
+	}
+
+	allowed, _ := values[0].(int64)
+
+	var retrySeconds float64
+	if _, err := fmt.Sscanf(fmt.Sprint(values[1]), "%g", &retrySeconds); err != nil {
+		return false, 0, fmt.Errorf("rate limit script: malformed retry-after %v: %w", values[1], err)
+	}
+
+	return allowed == 1, time.Duration(retrySeconds * float64(time.Second)), nil
+}
+
+// Allow checks if the request is allowed for the given IP. A Redis error
+// fails open (the request is allowed) rather than taking the whole service
+// down over a transient Redis hiccup.
+func (rl *RedisRateLimiter) Allow(ip string) bool {
+	allowed, _, err := rl.check(context.Background(), ip)
+	if err != nil {
+		slog.Error("Rate limit check failed, failing open", "ip", ip, "error", err)
+		return true
+	}
+	return allowed
+}
+
+// RateLimitMiddleware wraps an http.Handler to enforce the distributed rate
+// limit, failing open on Redis errors for the same reason Allow does.
+func (rl *RedisRateLimiter) RateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+
+		allowed, retryAfter, err := rl.check(r.Context(), ip)
+		if err != nil {
+			slog.Error("Rate limit check failed, failing open", "ip", ip, "error", err)
+			next(w, r)
+			return
+		}
+
+		if !allowed {
+			metrics.RateLimitDecisions.Inc(r.URL.Path, "deny")
+			writeTooManyRequests(w, retryAfter)
+			return
+		}
+
+		metrics.RateLimitDecisions.Inc(r.URL.Path, "allow")
+		next(w, r)
+	}
+}
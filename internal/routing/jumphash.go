@@ -0,0 +1,26 @@
+// Package routing implements consistent-hash job-to-worker assignment, so a
+// given job ID stays affine to the same worker across pool membership
+// changes (useful for keeping per-worker warmed language runtime/image
+// caches effective) without needing an external coordination service.
+package routing
+
+import "github.com/cespare/xxhash/v2"
+
+// Hash computes the Jump Consistent Hash bucket for key across numBuckets
+// buckets (Lamping & Veach, https://arxiv.org/abs/1406.2294). Unlike mod-N
+// hashing, changing numBuckets by one remaps only ~1/numBuckets of keys.
+// numBuckets must be positive.
+func Hash(key uint64, numBuckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// KeyFor hashes id (typically a Job.ID) into the 64-bit key Hash expects.
+func KeyFor(id string) uint64 {
+	return xxhash.Sum64String(id)
+}
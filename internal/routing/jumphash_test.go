@@ -0,0 +1,44 @@
+package routing
+
+import "testing"
+
+func TestHashIsWithinBucketRange(t *testing.T) {
+	for numBuckets := 1; numBuckets <= 32; numBuckets++ {
+		for key := uint64(0); key < 200; key++ {
+			bucket := Hash(key, numBuckets)
+			if bucket < 0 || bucket >= numBuckets {
+				t.Fatalf("Hash(%d, %d) = %d, want [0, %d)", key, numBuckets, bucket, numBuckets)
+			}
+		}
+	}
+}
+
+func TestHashIsStableForSameInputs(t *testing.T) {
+	key := KeyFor("job-123")
+	first := Hash(key, 10)
+	for i := 0; i < 100; i++ {
+		if got := Hash(key, 10); got != first {
+			t.Fatalf("Hash(%d, 10) = %d on call %d, want stable %d", key, got, i, first)
+		}
+	}
+}
+
+func TestHashMostlyStableAcrossBucketGrowth(t *testing.T) {
+	const before, after = 10, 11
+	var moved int
+	const total = 10000
+
+	for i := 0; i < total; i++ {
+		key := KeyFor(string(rune(i)))
+		if Hash(key, before) != Hash(key, after) {
+			moved++
+		}
+	}
+
+	// Jump Consistent Hash guarantees only ~1/numBuckets of keys remap when a
+	// bucket is added; allow generous slack above 1/after for noise.
+	maxExpected := total/after + total/10
+	if moved > maxExpected {
+		t.Fatalf("%d/%d keys remapped when growing %d->%d buckets, want <= %d", moved, total, before, after, maxExpected)
+	}
+}
@@ -0,0 +1,140 @@
+// Package runtime resolves a job's language to the Docker image, command,
+// and sandbox profile used to execute it, so docker.Client no longer has to
+// hardcode a single interpreter.
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// RuntimeProfile describes how to run one language inside an ephemeral,
+// locked-down container.
+type RuntimeProfile struct {
+	// Image is the Docker image tag to run the code in.
+	Image string `json:"image"`
+	// Cmd is the container entrypoint. When RequiresFile is false, the
+	// literal "{{CODE}}" placeholder in Cmd is substituted with the job's
+	// source; otherwise the code is written to SourcePath and Cmd is run as-is.
+	Cmd []string `json:"cmd"`
+	// FileExtension is appended to the source file written for compiled languages.
+	FileExtension string `json:"file_extension"`
+	// WorkDir is the container working directory the source file is written into.
+	WorkDir string `json:"work_dir"`
+	// RequiresFile is true for languages that must compile a file on disk
+	// (Go, Rust, C++) rather than accept code inline via -c/-e flags.
+	RequiresFile bool `json:"requires_file"`
+
+	// MemoryBytes is the hard cgroup memory limit.
+	MemoryBytes int64 `json:"memory_bytes"`
+	// PidsLimit caps the number of processes/threads (fork-bomb protection).
+	PidsLimit int64 `json:"pids_limit"`
+	// CPUQuota is the cgroup CPU quota in microseconds per 100ms period (CFS).
+	CPUQuota int64 `json:"cpu_quota"`
+
+	// NetworkMode is the Docker network mode, e.g. "none" to disable networking.
+	NetworkMode string `json:"network_mode"`
+	// ReadOnlyRootFS mounts the container root filesystem read-only.
+	ReadOnlyRootFS bool `json:"read_only_root_fs"`
+	// CapDrop lists Linux capabilities to drop, e.g. []string{"ALL"}.
+	CapDrop []string `json:"cap_drop"`
+	// SeccompProfile is a path to a seccomp JSON profile, or "" for the Docker default.
+	SeccompProfile string `json:"seccomp_profile"`
+	// TmpfsMounts maps container paths to their tmpfs mount options (e.g. "size=64m").
+	TmpfsMounts map[string]string `json:"tmpfs_mounts"`
+	// User is the "uid:gid" the container process runs as.
+	User string `json:"user"`
+}
+
+// SourcePath returns the path the code should be written to when RequiresFile is set.
+func (p RuntimeProfile) SourcePath() string {
+	return p.WorkDir + "/main" + p.FileExtension
+}
+
+// LanguageRegistry maps language IDs to their RuntimeProfile.
+type LanguageRegistry struct {
+	profiles map[string]RuntimeProfile
+}
+
+// NewLanguageRegistry returns a registry seeded with the built-in defaults.
+func NewLanguageRegistry() *LanguageRegistry {
+	return &LanguageRegistry{profiles: defaultProfiles()}
+}
+
+// LoadLanguageRegistry reads a JSON config file of {"language": RuntimeProfile}
+// and overlays it on top of the built-in defaults, so operators only need to
+// specify the languages/fields they want to customize.
+func LoadLanguageRegistry(path string) (*LanguageRegistry, error) {
+	reg := NewLanguageRegistry()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read language config %s: %w", path, err)
+	}
+
+	var overrides map[string]json.RawMessage
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse language config %s: %w", path, err)
+	}
+
+	for lang, raw := range overrides {
+		// Start from the built-in profile (zero for a new language) so
+		// fields the config omits keep their defaults.
+		profile := reg.profiles[lang]
+		if err := json.Unmarshal(raw, &profile); err != nil {
+			return nil, fmt.Errorf("failed to parse language config %s for %q: %w", path, lang, err)
+		}
+		reg.profiles[lang] = profile
+	}
+
+	return reg, nil
+}
+
+// Resolve returns the RuntimeProfile for a language, and whether it is known.
+func (lr *LanguageRegistry) Resolve(language string) (RuntimeProfile, bool) {
+	profile, ok := lr.profiles[language]
+	return profile, ok
+}
+
+// Languages returns the sorted set of supported language IDs, for advertising
+// to clients via GET /languages.
+func (lr *LanguageRegistry) Languages() []string {
+	langs := make([]string, 0, len(lr.profiles))
+	for lang := range lr.profiles {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// defaultProfiles returns the locked-down, zero-config set of supported
+// runtimes: network disabled, read-only rootfs, all capabilities dropped.
+func defaultProfiles() map[string]RuntimeProfile {
+	locked := func(image string, cmd []string, ext, workDir string, requiresFile bool) RuntimeProfile {
+		return RuntimeProfile{
+			Image:          image,
+			Cmd:            cmd,
+			FileExtension:  ext,
+			WorkDir:        workDir,
+			RequiresFile:   requiresFile,
+			MemoryBytes:    512 * 1024 * 1024,
+			PidsLimit:      64,
+			CPUQuota:       50000, // 0.5 CPU
+			NetworkMode:    "none",
+			ReadOnlyRootFS: true,
+			CapDrop:        []string{"ALL"},
+			TmpfsMounts:    map[string]string{workDir: "size=64m"},
+			User:           "65534:65534", // nobody:nobody
+		}
+	}
+
+	return map[string]RuntimeProfile{
+		"python": locked("python:alpine", []string{"python", "-c", "{{CODE}}"}, ".py", "/tmp/sandbox", false),
+		"node":   locked("node:alpine", []string{"node", "-e", "{{CODE}}"}, ".js", "/tmp/sandbox", false),
+		"bash":   locked("alpine", []string{"sh", "-c", "{{CODE}}"}, ".sh", "/tmp/sandbox", false),
+		"ruby":   locked("ruby:alpine", []string{"ruby", "-e", "{{CODE}}"}, ".rb", "/tmp/sandbox", false),
+		"go":     locked("golang:alpine", []string{"sh", "-c", "cd /tmp/sandbox && go run main.go"}, ".go", "/tmp/sandbox", true),
+	}
+}
@@ -0,0 +1,15 @@
+package runtime
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestLanguagesReturnsSortedIDs(t *testing.T) {
+	reg := NewLanguageRegistry()
+
+	langs := reg.Languages()
+	if !sort.StringsAreSorted(langs) {
+		t.Fatalf("Languages() = %v, want sorted", langs)
+	}
+}
@@ -2,59 +2,217 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dontdude/goxec/internal/domain"
+	"github.com/dontdude/goxec/internal/metrics"
 )
 
+// errPoolShuttingDown is returned by Submit once Shutdown has started, so
+// callers feeding jobs from a queue subscription treat it like any other
+// rejection (leave the job unacked for redelivery) instead of racing
+// Shutdown's close(p.tasksCh).
+var errPoolShuttingDown = errors.New("worker pool: shutting down, not accepting new jobs")
+
+// StreamPublisher publishes incremental job output as it is produced, e.g.
+// RedisQueue.PublishStream. It is intentionally smaller than domain.JobQueue
+// since not every queue backend needs to support live streaming.
+type StreamPublisher interface {
+	PublishStream(ctx context.Context, jobID string, event domain.StreamEvent) error
+}
+
+// streamBufferSize bounds how many unpublished StreamEvents a job may queue
+// up before new ones are dropped, so a slow publisher can't stall execution.
+const streamBufferSize = 64
+
 // Pool implements a fixed-size worker pool pattern.
 // It throttles the concurrent execution of code usage using a buffered channel or fixed goroutines.
 type Pool struct {
 	// workerCount determines how many concurrent Docker containers can run.
 	workerCount int
 	// tasksCh is the queue for incoming jobs.
-	tasksCh chan domain.Job
+	tasksCh chan queuedJob
 	// wg tracks active workers to ensure graceful shutdown.
 	wg sync.WaitGroup
 	runner domain.ContainerRunner
+
+	// queue acknowledges or dead-letters jobs pulled from a JobQueue.
+	// It is nil when the pool is fed directly via Submit (no backing queue),
+	// in which case ack/dead-letter bookkeeping is skipped entirely.
+	queue domain.JobQueue
+
+	// streamer publishes live StreamEvents while a job runs. It is nil when
+	// no live-streaming subsystem is wired up, in which case Run is simply
+	// not given an events channel.
+	streamer StreamPublisher
+
+	// rootCtx is the context every in-flight job's context is derived from.
+	// Cancelling it (or an individual entry in cancels) unblocks that job's
+	// ContainerRunner.Run so Shutdown's grace period can force a timely exit.
+	rootCtx context.Context
+
+	mu        sync.Mutex
+	cancels   map[int64]context.CancelFunc
+	nextToken int64
+
+	// submitMu guards closed and serializes it against Submit's send on
+	// tasksCh: Submit holds it for reading while sending, Shutdown takes it
+	// exclusively before closing the channel, so a send can never land on an
+	// already-closed channel.
+	submitMu sync.RWMutex
+	closed   bool
+
+	// completed and killed count toward the ShutdownSummary Shutdown returns.
+	completed int64
+	killed    int64
 }
 
-// NewPool initializes the worker pool with a fixed concurrency limit. 
+// ShutdownSummary reports how a graceful shutdown concluded: Completed is how
+// many in-flight or already-queued jobs finished on their own before the
+// deadline, Killed is
+// how many were still running when the hammer cancelled them. Operators can
+// alert on a non-zero Killed count.
+type ShutdownSummary struct {
+	Completed int
+	Killed    int
+}
+
+// NewPool initializes the worker pool with a fixed concurrency limit.
 func NewPool(concurrency int, runner domain.ContainerRunner) *Pool {
 	return &Pool{
 		workerCount: concurrency,
 		// Buffer the channel to allow non-blocking submission up to a certain point.
-		tasksCh: make(chan domain.Job, concurrency),
-		runner: runner,
+		tasksCh: make(chan queuedJob, concurrency),
+		runner:  runner,
+		cancels: make(map[int64]context.CancelFunc),
 	}
 }
 
-// Start spawns the fixed number of worker goroutines.
-// It returns immediately.
-func (p *Pool) Start() {
+// queuedJob pairs a job with the time it was accepted by Submit, so the
+// worker that eventually dequeues it can report how long it sat waiting.
+type queuedJob struct {
+	job         domain.Job
+	submittedAt time.Time
+}
+
+// SetQueue attaches the JobQueue a job was pulled from, so completed jobs are
+// acknowledged and failed jobs are dead-lettered once they exceed their retry
+// budget, instead of every completion being ACKed unconditionally.
+func (p *Pool) SetQueue(q domain.JobQueue) {
+	p.queue = q
+}
+
+// SetStreamer attaches a StreamPublisher so running jobs publish incremental
+// output for live subscribers, instead of only delivering the final JobResult.
+func (p *Pool) SetStreamer(s StreamPublisher) {
+	p.streamer = s
+}
+
+// Start spawns the fixed number of worker goroutines. ctx is the root
+// context every in-flight job's context is derived from. It should have a
+// lifetime independent of any shutdown signal: Shutdown cuts off in-flight
+// jobs itself once its own deadline elapses, and if ctx were cancelled the
+// instant a shutdown signal arrived, every job would be killed immediately
+// instead of being given a grace period to finish.
+// Start returns immediately.
+func (p *Pool) Start(ctx context.Context) {
 	slog.Info("Starting worker pool", "concurrency", p.workerCount)
 
+	p.rootCtx = ctx
 	for i := 0; i < p.workerCount; i++ {
 		p.wg.Add(1)
 		go p.worker(i)
 	}
 }
 
-// Stop initiates a graceful shutdown.
-// It closes the jobs channel, which signals all workers to finish their current task and exit.
-// It blocks until all workers have exited. 
-func (p *Pool) Stop() {
-	slog.Info("Stopping worker pool, waiting for tasks to drain...")
+// Shutdown initiates a two-phase graceful shutdown: it closes the jobs
+// channel so no new job is accepted (jobs already buffered in it still
+// run), then waits for in-flight jobs to finish on
+// their own until ctx is done ("hammer time"), at which point it force
+// cancels every still-running job's context. That unblocks
+// ContainerRunner.Run (and the deferred, Force: true container removal
+// behind it), so a job that ignored the grace period is killed rather than
+// leaked. Shutdown blocks until every worker has exited and returns a
+// summary of how many jobs completed on their own versus were killed.
+func (p *Pool) Shutdown(ctx context.Context) ShutdownSummary {
+	slog.Info("Shutting down worker pool, waiting for in-flight jobs to drain")
+
+	// Only jobs that conclude from here on count toward the summary.
+	completedBefore := atomic.LoadInt64(&p.completed)
+	killedBefore := atomic.LoadInt64(&p.killed)
+
+	p.submitMu.Lock()
+	p.closed = true
 	close(p.tasksCh)
-	p.wg.Wait()
-	slog.Info("Worker pool stopped")
+	p.submitMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		slog.Info("Worker pool stopped")
+	case <-ctx.Done():
+		slog.Warn("Grace period elapsed, cancelling in-flight jobs")
+		p.cancelAll()
+		<-done
+		slog.Info("Worker pool stopped after cancelling in-flight jobs")
+	}
+
+	return ShutdownSummary{
+		Completed: int(atomic.LoadInt64(&p.completed) - completedBefore),
+		Killed:    int(atomic.LoadInt64(&p.killed) - killedBefore),
+	}
+}
+
+// cancelAll cancels the context of every job still in flight.
+func (p *Pool) cancelAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, cancel := range p.cancels {
+		cancel()
+	}
 }
 
-// Submit adds a job to the queue.
-// It blocks if the queue (and workers) are fully saturated.
-func (p *Pool) Submit(job domain.Job) {
-	p.tasksCh <- job
+// ImageAvailabilityChecker lets a ContainerRunner veto a job before it's
+// queued, when it already knows the job's language is currently failing
+// (e.g. docker.Client's circuit breaker after repeated pull/create
+// failures). It's optional: runners that don't implement it are assumed
+// always available.
+type ImageAvailabilityChecker interface {
+	Available(language string) error
+}
+
+// Submit adds a job to the queue, or returns the runner's error immediately
+// without queueing if the runner implements ImageAvailabilityChecker and
+// reports job.Language as currently unavailable. It otherwise blocks if the
+// queue (and workers) are fully saturated. Once Shutdown has started, Submit
+// returns errPoolShuttingDown instead of sending, since tasksCh is closed as
+// part of Shutdown and sending on a closed channel would panic.
+func (p *Pool) Submit(job domain.Job) error {
+	if checker, ok := p.runner.(ImageAvailabilityChecker); ok {
+		if err := checker.Available(job.Language); err != nil {
+			return err
+		}
+	}
+
+	p.submitMu.RLock()
+	defer p.submitMu.RUnlock()
+	if p.closed {
+		return errPoolShuttingDown
+	}
+
+	metrics.JobsSubmitted.Inc()
+	p.tasksCh <- queuedJob{job: job, submittedAt: time.Now()}
+	return nil
 }
 
 // worker is the core logic that runs inside a goroutine.
@@ -62,22 +220,163 @@ func (p *Pool) worker(id int) {
 	defer p.wg.Done()
 	slog.Info("Worker started", "workerId", id)
 
-	// Range over the channel continuously reads jobs until the channel is closed. 
-	for job := range p.tasksCh {
+	// Range over the channel continuously reads jobs until the channel is closed.
+	for qj := range p.tasksCh {
+		job := qj.job
 		slog.Debug("Processing job", "workerId", id, "jobID", job.ID)
+		metrics.QueueWait.Observe(time.Since(qj.submittedAt).Seconds())
 
-		// Create a separate context for the execution to ensure independent timeouts
-		// In a real app, you might inherit from a parent context or allow the job to specify one.
-		ctx := context.Background()
+		start := time.Now()
+		ctx, cancel, token := p.trackJobContext(job)
+		result, err := p.runStreaming(ctx, job)
+		p.untrackJobContext(token)
+		metrics.ExecutionTime.Observe(time.Since(start).Seconds(), job.Language)
 
-		output, err := p.runner.Run(ctx, job.Code, job.Language)
+		// ctx.Err() == context.Canceled here means Shutdown's grace period (or an
+		// explicit rootCtx cancellation) cut the job off mid-flight, as
+		// opposed to context.DeadlineExceeded, which means the job's own
+		// TimeoutSeconds elapsed normally. A job cut off by shutdown never
+		// produced a real result, so it must not be acknowledged or have a
+		// stale result cached: leaving it unacked lets the queue's recovery
+		// routine redeliver it once a worker is running again.
+		cutOffByShutdown := ctx.Err() == context.Canceled
+		cancel()
 
-		// Report result
-		job.ResultCh <- domain.JobResult{
-			Output: output,
-			Error: err,
+		if cutOffByShutdown {
+			atomic.AddInt64(&p.killed, 1)
+			slog.Warn("Job cancelled by shutdown, leaving unacknowledged for recovery", "jobID", job.ID)
+			continue
+		}
+		atomic.AddInt64(&p.completed, 1)
+
+		result.JobID = job.ID
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		metrics.JobsCompleted.Inc(job.Language)
+		if reason := classifyFailure(result, err); reason != "" {
+			metrics.JobsFailed.Inc(reason)
+		}
+
+		// Bookkeeping writes use a fresh context rather than the job's own
+		// (possibly just-expired) one, so a job that legitimately timed out
+		// still gets its result cached and acknowledged/dead-lettered.
+		bookkeepingCtx := context.Background()
+
+		if p.queue != nil {
+			if err := p.queue.StoreResult(bookkeepingCtx, result); err != nil {
+				slog.Error("Failed to cache job result", "jobID", job.ID, "error", err)
+			}
 		}
+
+		p.settle(bookkeepingCtx, job, err)
+
+		// Report result
+		job.ResultCh <- result
 	}
 
 	slog.Info("Worker stopped", "workerID", id)
+}
+
+// trackJobContext derives job's execution context from rootCtx, applying
+// job.TimeoutSeconds as a deadline when set, and registers its cancel func
+// so Shutdown can cut the job off once the grace period elapses.
+func (p *Pool) trackJobContext(job domain.Job) (context.Context, context.CancelFunc, int64) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if job.TimeoutSeconds > 0 {
+		ctx, cancel = context.WithTimeout(p.rootCtx, time.Duration(job.TimeoutSeconds)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(p.rootCtx)
+	}
+
+	p.mu.Lock()
+	token := p.nextToken
+	p.nextToken++
+	p.cancels[token] = cancel
+	p.mu.Unlock()
+
+	return ctx, cancel, token
+}
+
+// untrackJobContext removes a finished job's cancel func once it no longer
+// needs to be reachable by Shutdown's grace-period cutoff.
+func (p *Pool) untrackJobContext(token int64) {
+	p.mu.Lock()
+	delete(p.cancels, token)
+	p.mu.Unlock()
+}
+
+// runStreaming invokes the ContainerRunner, forwarding any StreamEvents it
+// produces to p.streamer as they arrive. When no streamer is configured, the
+// runner is simply not given an events channel.
+func (p *Pool) runStreaming(ctx context.Context, job domain.Job) (domain.JobResult, error) {
+	if p.streamer == nil {
+		return p.runner.Run(ctx, job.ID, job.Code, job.Language, nil)
+	}
+
+	eventsCh := make(chan domain.StreamEvent, streamBufferSize)
+	var pubWg sync.WaitGroup
+	pubWg.Add(1)
+	go func() {
+		defer pubWg.Done()
+		for event := range eventsCh {
+			if err := p.streamer.PublishStream(ctx, job.ID, event); err != nil {
+				slog.Error("Failed to publish stream event", "jobID", job.ID, "kind", event.Kind, "error", err)
+			}
+		}
+	}()
+
+	result, err := p.runner.Run(ctx, job.ID, job.Code, job.Language, eventsCh)
+	close(eventsCh)
+	pubWg.Wait()
+
+	return result, err
+}
+
+// classifyFailure maps a finished job's outcome to a metrics.JobsFailed
+// reason, or "" if the job succeeded.
+func classifyFailure(result domain.JobResult, runErr error) string {
+	switch {
+	case result.TimedOut:
+		return metrics.ReasonTimeout
+	case result.OOMKilled:
+		return metrics.ReasonOOM
+	case runErr != nil && strings.Contains(runErr.Error(), "pull image"):
+		return metrics.ReasonImagePull
+	case runErr != nil:
+		return metrics.ReasonRuntimeError
+	default:
+		return ""
+	}
+}
+
+// settle reports a finished job back to its originating queue: success is
+// ACKed so it leaves the PEL, failure is dead-lettered once the job's retry
+// budget (domain.Job.MaxRetries) is exhausted, and otherwise left unacked so
+// the queue's own recovery routine redelivers it.
+func (p *Pool) settle(ctx context.Context, job domain.Job, runErr error) {
+	if p.queue == nil || job.RawID == "" {
+		return
+	}
+
+	if runErr == nil {
+		if err := p.queue.Acknowledge(ctx, job.RawID); err != nil {
+			slog.Error("Failed to acknowledge completed job", "jobID", job.ID, "error", err)
+		}
+		return
+	}
+
+	job.DeliveryCount++
+	job.LastError = runErr.Error()
+
+	if job.MaxRetries > 0 && job.DeliveryCount > job.MaxRetries {
+		if err := p.queue.DeadLetter(ctx, job, job.LastError); err != nil {
+			slog.Error("Failed to dead-letter job", "jobID", job.ID, "error", err)
+		}
+		return
+	}
+
+	slog.Warn("Job failed, leaving unacked for retry", "jobID", job.ID, "deliveryCount", job.DeliveryCount, "error", runErr)
 }
\ No newline at end of file